@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+// adminAPI lets operators create, list and expire silences. Every request has to carry the API key, either in the
+// X-Api-Key header or as a bearer token.
+type adminAPI struct {
+	silences *silences
+	apiKey   string
+}
+
+// maxSilenceRequestSize is the maximum size in bytes of the body of a request creating a silence.
+const maxSilenceRequestSize = 64 << 10
+
+// createSilenceRequest is a silence ending either at EndsAt or after Duration (e.g. "2h").
+type createSilenceRequest struct {
+	Cluster       *pattern  `json:"cluster"`
+	ConsumerGroup *pattern  `json:"consumerGroup"`
+	Topic         *pattern  `json:"topic"`
+	Reason        string    `json:"reason"`
+	EndsAt        time.Time `json:"endsAt"`
+	Duration      string    `json:"duration"`
+}
+
+func newAdminAPI(silences *silences, apiKey string) *adminAPI {
+	return &adminAPI{silences: silences, apiKey: apiKey}
+}
+
+func (a *adminAPI) register(router *mux.Router) {
+	router.Path("/__silences").Handler(a.authenticated(handlers.MethodHandler{
+		"GET":  http.HandlerFunc(a.listSilences),
+		"POST": http.HandlerFunc(a.createSilence),
+	}))
+	router.Path("/__silences/{id}").Handler(a.authenticated(handlers.MethodHandler{
+		"DELETE": http.HandlerFunc(a.expireSilence),
+	}))
+}
+
+func (a *adminAPI) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Api-Key")
+		if key == "" {
+			key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(a.apiKey)) != 1 {
+			writeJSONMessage(w, http.StatusUnauthorized, "Invalid or missing API key.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *adminAPI) listSilences(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.silences.active(time.Now()))
+}
+
+func (a *adminAPI) createSilence(w http.ResponseWriter, r *http.Request) {
+	var req createSilenceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSilenceRequestSize)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONMessage(w, http.StatusRequestEntityTooLarge, "Silence must be at most "+strconv.Itoa(maxSilenceRequestSize)+" bytes.")
+			return
+		}
+		writeJSONMessage(w, http.StatusBadRequest, "Could not decode silence: "+err.Error())
+		return
+	}
+	now := time.Now()
+	created := silence{Cluster: req.Cluster, ConsumerGroup: req.ConsumerGroup, Topic: req.Topic, Reason: req.Reason, EndsAt: req.EndsAt}
+	if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			writeJSONMessage(w, http.StatusBadRequest, "Invalid duration: "+err.Error())
+			return
+		}
+		created.EndsAt = now.Add(duration)
+	}
+
+	created, err := a.silences.add(created, now)
+	var invalid invalidSilenceError
+	if errors.As(err, &invalid) {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Error("Could not create silence", "error", err)
+		writeJSONMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logger.Info("Created silence", "silence", created.ID, "ends_at", created.EndsAt, "reason", created.Reason)
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (a *adminAPI) expireSilence(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	found, err := a.silences.expire(id, time.Now())
+	if err != nil {
+		writeJSONMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "No active silence with ID "+id+".")
+		return
+	}
+	logger.Info("Expired silence", "silence", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("Could not write response", "error", err)
+	}
+}
+
+func writeJSONMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAPI(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	s, err := newSilences("")
+	assert.NoError(t, err)
+	router := mux.NewRouter()
+	newAdminAPI(s, "secret").register(router)
+
+	do := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/__silences", "", "").Code, "missing key")
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/__silences", "", "wrong").Code, "wrong key")
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/__silences", `{"consumerGroup": "content-*", "reason": "Reindexing", "duration": "2h"}`, "").Code)
+
+	w := do("POST", "/__silences", `{"consumerGroup": "content-*", "reason": "Reindexing", "duration": "2h"}`, "secret")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "content-*", created["consumerGroup"])
+	assert.Equal(t, "Reindexing", created["reason"])
+	endsAt, err := time.Parse(time.RFC3339, created["endsAt"].(string))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), endsAt, time.Minute)
+
+	endsAtParam := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w = do("POST", "/__silences", `{"topic": "/^Concept/", "reason": "Concept migration", "endsAt": "`+endsAtParam+`"}`, "secret")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	for _, body := range []string{`{"consumerGroup": "/(/", "reason": "Reindexing", "duration": "2h"}`, `{"consumerGroup": "content-*", "duration": "2h"}`, `{"consumerGroup": "content-*", "reason": "Reindexing", "duration": "2 hours"}`, `not json`} {
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/__silences", body, "secret").Code, body)
+	}
+
+	req := httptest.NewRequest("GET", "/__silences", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "bearer token")
+	var listed []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed, 2)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/__silences/"+created["id"].(string), "", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/__silences/"+created["id"].(string), "", "secret").Code)
+	assert.Len(t, s.active(time.Now()), 1)
+}
+
+func TestAdminAPICreateSilenceFailures(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	s, err := newSilences(filepath.Join(t.TempDir(), "missing", "silences.json"))
+	assert.NoError(t, err)
+	router := mux.NewRouter()
+	newAdminAPI(s, "secret").register(router)
+
+	do := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/__silences", strings.NewReader(body))
+		req.Header.Set("X-Api-Key", "secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(`{"consumerGroup": "content-*", "duration": "2h"}`).Code, "invalid silence")
+	assert.Equal(t, http.StatusInternalServerError, do(`{"consumerGroup": "content-*", "reason": "Reindexing", "duration": "2h"}`).Code, "silences file not writable")
+	assert.Empty(t, s.active(time.Now()))
+
+	tooLarge := `{"consumerGroup": "content-*", "reason": "` + strings.Repeat("Reindexing ", maxSilenceRequestSize/10) + `", "duration": "2h"}`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(tooLarge).Code)
+}
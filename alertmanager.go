@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the alerts pushed to Alertmanager.
+const (
+	alertConsumerGroupLagging = "KafkaConsumerGroupLagging"
+	alertBurrowUnavailable    = "KafkaLagcheckBurrowUnavailable"
+)
+
+// alertmanagerTimeout is the time limit to push the alerts to an Alertmanager instance.
+const alertmanagerTimeout = 10 * time.Second
+
+// alertmanagerAlert is an alert as posted to the /api/v2/alerts endpoint of Alertmanager.
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// alertPusher pushes the failing checks to Alertmanager as alerts, as tracked by a checkTracker. New and resolved alerts
+// are pushed after the poll that found them, and all the firing ones again every resend interval. A firing alert ends
+// 4 resend or poll intervals, whichever is longer, after it was last pushed, so that Alertmanager resolves it on its
+// own if the service stops.
+type alertPusher struct {
+	urls           []string
+	client         *http.Client
+	resendInterval time.Duration
+	validFor       time.Duration
+
+	mu       sync.Mutex
+	tracker  *checkTracker
+	firing   map[string]alertmanagerAlert
+	lastPush time.Time
+
+	deliveries orderedDeliveries
+}
+
+// newAlertPusher creates an alertPusher pushing to the Alertmanager instances at the base URLs (e.g. http://alertmanager:9093).
+func newAlertPusher(urls []string, client *http.Client, resendInterval time.Duration, pollInterval time.Duration) *alertPusher {
+	var endpoints []string
+	for _, url := range urls {
+		endpoints = append(endpoints, strings.TrimSuffix(url, "/")+"/api/v2/alerts")
+	}
+	validFor := 4 * resendInterval
+	if pollInterval > resendInterval {
+		validFor = 4 * pollInterval
+	}
+	return &alertPusher{urls: endpoints, client: client, resendInterval: resendInterval, validFor: validFor, tracker: newCheckTracker(), firing: map[string]alertmanagerAlert{}}
+}
+
+// pushAlerts makes the alertPusher observe the evaluation of every new snapshot.
+func (h *healthcheck) pushAlerts(p *alertPusher) {
+	h.poller.onSnapshot(func(snapshot *lagSnapshot) {
+		p.observe(h.evaluate(snapshot))
+	})
+}
+
+// observe updates the alerts from the evaluation, and pushes them if any started or was resolved, or if the resend
+// interval is over.
+func (p *alertPusher) observe(e *evaluation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var resolved []alertmanagerAlert
+	transitions := p.tracker.observe(e)
+	for _, transition := range transitions {
+		if transition.state.failing {
+			p.firing[transition.key] = newAlertmanagerAlert(transition.state, transition.since)
+			continue
+		}
+		alert := p.firing[transition.key]
+		alert.EndsAt = e.at.UTC()
+		resolved = append(resolved, alert)
+		delete(p.firing, transition.key)
+	}
+	for _, key := range p.tracker.failingKeys() {
+		alert := p.firing[key]
+		alert.Annotations = alertAnnotations(p.tracker.failing[key].state)
+		p.firing[key] = alert
+	}
+
+	if len(transitions) == 0 && e.at.Sub(p.lastPush) < p.resendInterval {
+		return
+	}
+	p.lastPush = e.at
+	alerts := resolved
+	for _, key := range p.tracker.failingKeys() {
+		alert := p.firing[key]
+		alert.EndsAt = e.at.Add(p.validFor).UTC()
+		alerts = append(alerts, alert)
+	}
+	if len(alerts) > 0 {
+		p.deliver(alerts)
+	}
+}
+
+// deliver pushes the alerts to every Alertmanager instance in the background, after the ones previously delivered.
+func (p *alertPusher) deliver(alerts []alertmanagerAlert) {
+	p.deliveries.start("alertmanager", func() {
+		body, err := json.Marshal(alerts)
+		if err != nil {
+			logger.Warn("Could not encode alerts", "error", err)
+			return
+		}
+		for _, url := range p.urls {
+			if err := p.post(url, body); err != nil {
+				logger.Warn("Could not push alerts to Alertmanager", "alerts", len(alerts), "error", err)
+			}
+		}
+	})
+}
+
+func (p *alertPusher) post(url string, body []byte) error {
+	resp, err := p.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Alertmanager returned status %d for %s", resp.StatusCode, url)
+	}
+	return nil
+}
+
+// wait waits for the alerts being pushed.
+func (p *alertPusher) wait() {
+	p.deliveries.wait()
+}
+
+// newAlertmanagerAlert creates the alert of a failing check. Its labels identify it in Alertmanager, so they are set
+// once, even if the topic the consumer group lags on most changes while the alert is firing.
+func newAlertmanagerAlert(state checkState, startsAt time.Time) alertmanagerAlert {
+	labels := map[string]string{
+		"alertname": alertConsumerGroupLagging,
+		"service":   systemCode,
+		"severity":  strconv.Itoa(int(state.severity)),
+	}
+	if state.kind == burrowCheck {
+		labels["alertname"] = alertBurrowUnavailable
+	}
+	for name, value := range map[string]string{
+		"cluster":        state.cluster,
+		"consumer_group": state.consumerGroup,
+		"topic":          state.topic,
+		"team":           state.team,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return alertmanagerAlert{Labels: labels, Annotations: alertAnnotations(state), StartsAt: startsAt.UTC()}
+}
+
+func alertAnnotations(state checkState) map[string]string {
+	return map[string]string{
+		"summary":           state.name,
+		"description":       state.message,
+		"technical_summary": state.technicalSummary,
+		"business_impact":   state.businessImpact,
+		"runbook_url":       state.panicGuide,
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// alertmanagerStandIn records the alerts pushed to its /api/v2/alerts endpoint.
+type alertmanagerStandIn struct {
+	*httptest.Server
+	mu     sync.Mutex
+	pushes [][]alertmanagerAlert
+}
+
+func newAlertmanagerStandIn(t *testing.T) *alertmanagerStandIn {
+	s := &alertmanagerStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/alerts", r.URL.Path)
+		assert.Equal(t, http.MethodPost, r.Method)
+		var alerts []alertmanagerAlert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alerts))
+		s.mu.Lock()
+		s.pushes = append(s.pushes, alerts)
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+// received returns the alerts pushed since the last call.
+func (s *alertmanagerStandIn) received() [][]alertmanagerAlert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pushes := s.pushes
+	s.pushes = nil
+	return pushes
+}
+
+func TestAlertPusher(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	am := newAlertmanagerStandIn(t)
+	defer am.Close()
+	h := newNotifierTestHealthcheck(nil)
+	conf, err := parseConfig([]byte(`{"checks": [{"consumerGroup": "content-*", "team": "content", "severity": 2}]}`))
+	assert.NoError(t, err)
+	h.update(&lagSettings{source: h.current().source, filters: h.current().filters, thresholds: h.current().thresholds, checks: newCheckRules(conf.Checks)})
+	p := newAlertPusher([]string{am.URL + "/"}, http.DefaultClient, time.Minute, 30*time.Second)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	lagging := errors.New("content-notifications consumer group is lagging behind with 20 messages.")
+
+	p.observe(h.evaluate(snapshotAt(start, groupLag("content-notifications", lagging), groupLag("concept-reader", nil))))
+	p.wait()
+	pushes := am.received()
+	if assert.Len(t, pushes, 1) && assert.Len(t, pushes[0], 1) {
+		alert := pushes[0][0]
+		assert.Equal(t, map[string]string{
+			"alertname":      "KafkaConsumerGroupLagging",
+			"service":        "kafka-lagcheck",
+			"severity":       "2",
+			"cluster":        "local",
+			"consumer_group": "content-notifications",
+			"team":           "content",
+		}, alert.Labels)
+		assert.Equal(t, lagging.Error(), alert.Annotations["description"])
+		assert.Equal(t, "Consumer group content-notifications on cluster local is lagging.", alert.Annotations["summary"])
+		assert.Equal(t, "https://runbooks.in.ft.com/kafka-lagcheck", alert.Annotations["runbook_url"])
+		assert.Equal(t, start, alert.StartsAt)
+		assert.Equal(t, start.Add(4*time.Minute), alert.EndsAt)
+	}
+
+	// nothing changed and the resend interval isn't over, then it is
+	p.observe(h.evaluate(snapshotAt(start.Add(30*time.Second), groupLag("content-notifications", lagging))))
+	p.wait()
+	assert.Empty(t, am.received())
+	p.observe(h.evaluate(snapshotAt(start.Add(time.Minute), groupLag("content-notifications", lagging))))
+	p.wait()
+	pushes = am.received()
+	if assert.Len(t, pushes, 1) && assert.Len(t, pushes[0], 1) {
+		assert.Equal(t, start, pushes[0][0].StartsAt, "still the same alert")
+		assert.Equal(t, start.Add(5*time.Minute), pushes[0][0].EndsAt)
+	}
+
+	// unknown lag keeps the alert firing, Burrow being unavailable fires another one
+	unknown := groupLag("content-notifications", nil)
+	unknown.unknown = errors.New("context deadline exceeded")
+	p.observe(h.evaluate(snapshotAt(start.Add(90*time.Second), unknown)))
+	p.observe(h.evaluate(&lagSnapshot{fetchedAt: start.Add(2 * time.Minute), err: errors.New("Burrow returned status 503")}))
+	p.wait()
+	pushes = am.received()
+	if assert.Len(t, pushes, 1) && assert.Len(t, pushes[0], 2) {
+		assert.Equal(t, "KafkaLagcheckBurrowUnavailable", pushes[0][0].Labels["alertname"])
+		assert.Equal(t, "Error retrieving cluster list.", pushes[0][0].Annotations["description"])
+		assert.Equal(t, "KafkaConsumerGroupLagging", pushes[0][1].Labels["alertname"])
+	}
+
+	// recovery
+	p.observe(h.evaluate(snapshotAt(start.Add(150*time.Second), groupLag("content-notifications", nil))))
+	p.wait()
+	pushes = am.received()
+	if assert.Len(t, pushes, 1) && assert.Len(t, pushes[0], 2) {
+		for _, alert := range pushes[0] {
+			assert.Equal(t, start.Add(150*time.Second), alert.EndsAt, alert.Labels["alertname"])
+		}
+	}
+
+	// nothing firing anymore
+	p.observe(h.evaluate(snapshotAt(start.Add(time.Hour), groupLag("content-notifications", nil))))
+	p.wait()
+	assert.Empty(t, am.received())
+}
+
+func TestAlertPusherAlertmanagerDown(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	am := newAlertmanagerStandIn(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer am.Close()
+	defer down.Close()
+	h := newNotifierTestHealthcheck(nil)
+	p := newAlertPusher([]string{down.URL, am.URL}, http.DefaultClient, time.Minute, time.Minute)
+
+	p.observe(h.evaluate(snapshotAt(time.Now(), groupLag("content-notifications", errors.New("lagging")))))
+	p.wait()
+	assert.Len(t, am.received(), 1, "pushed to the other instance")
+	assert.Equal(t, 4*time.Minute, p.validFor)
+}
+
+func TestAlertPusherMissingCriticalGroup(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	am := newAlertmanagerStandIn(t)
+	defer am.Close()
+	h := newNotifierTestHealthcheck(nil)
+	conf, err := parseConfig([]byte(`{"checks": [{"consumerGroup": "content-notifications", "critical": true, "team": "content"}]}`))
+	assert.NoError(t, err)
+	h.update(&lagSettings{source: h.current().source, filters: h.current().filters, thresholds: h.current().thresholds, checks: newCheckRules(conf.Checks)})
+	p := newAlertPusher([]string{am.URL}, http.DefaultClient, time.Minute, time.Minute)
+
+	p.observe(h.evaluate(snapshotAt(time.Now(), groupLag("concept-reader", nil))))
+	p.wait()
+	pushes := am.received()
+	if assert.Len(t, pushes, 1) && assert.Len(t, pushes[0], 1) {
+		assert.Equal(t, map[string]string{
+			"alertname":      alertConsumerGroupLagging,
+			"service":        systemCode,
+			"severity":       "1",
+			"consumer_group": "content-notifications",
+			"team":           "content",
+		}, pushes[0][0].Labels)
+	}
+}
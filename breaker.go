@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of making a request to Burrow while the circuit breaker is open.
+var errCircuitOpen = errors.New("Burrow is unavailable, the circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+var circuitStates = []circuitState{circuitClosed, circuitHalfOpen, circuitOpen}
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops the requests to Burrow once threshold consecutive ones failed, as Burrow is then clearly down.
+// After cooldown a single trial request is let through: the breaker closes if it succeeds and opens again otherwise.
+// A nil *circuitBreaker never opens.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// breakerStatus is the state of the circuit breaker at a point in time.
+type breakerStatus struct {
+	state    circuitState
+	failures int
+	openedAt time.Time
+	retryAt  time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow tells whether a request can be made to Burrow, letting a trial request through once the cooldown is over.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if now.Before(b.openedAt.Add(b.cooldown)) {
+			return false
+		}
+		b.state = circuitHalfOpen
+		logger.Info("Circuit breaker half-open, trying a request to Burrow")
+		return true
+	case circuitHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// record counts the outcome of a request to Burrow. Only the requests that couldn't reach Burrow, timed out or got a
+// server error count as failures: Burrow answering that a consumer group doesn't exist means it is up.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	if b == nil {
+		return
+	}
+	failed := isTransient(err) || isTimeout(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.threshold > 0 && b.failures >= b.threshold {
+			b.open(now)
+		}
+	case circuitHalfOpen:
+		if failed {
+			b.failures++
+			b.open(now)
+			return
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		logger.Info("Circuit breaker closed, Burrow is available again")
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = circuitOpen
+	b.openedAt = now
+	logger.Warn("Circuit breaker open, no requests are made to Burrow until the cool down is over", "failures", b.failures, "cooldown", b.cooldown)
+}
+
+func (b *circuitBreaker) status() breakerStatus {
+	if b == nil {
+		return breakerStatus{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := breakerStatus{state: b.state, failures: b.failures}
+	if b.state != circuitClosed {
+		status.openedAt = b.openedAt
+		status.retryAt = b.openedAt.Add(b.cooldown)
+	}
+	return status
+}
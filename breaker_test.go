@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	b := newCircuitBreaker(3, time.Minute)
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	unavailable := &burrow.Error{StatusCode: 503}
+
+	b.record(unavailable, start)
+	b.record(unavailable, start)
+	b.record(&burrow.Error{StatusCode: 404}, start)
+	b.record(unavailable, start)
+	b.record(context.DeadlineExceeded, start)
+	assert.True(t, b.allow(start), "a success in between resets the failures")
+	assert.Equal(t, circuitClosed, b.status().state)
+
+	b.record(unavailable, start.Add(time.Second))
+	status := b.status()
+	assert.Equal(t, circuitOpen, status.state)
+	assert.Equal(t, 3, status.failures)
+	assert.Equal(t, start.Add(time.Second), status.openedAt)
+	assert.Equal(t, start.Add(61*time.Second), status.retryAt)
+	assert.False(t, b.allow(start.Add(time.Minute)), "cooling down")
+
+	assert.True(t, b.allow(start.Add(61*time.Second)), "trial request")
+	assert.Equal(t, circuitHalfOpen, b.status().state)
+	assert.False(t, b.allow(start.Add(61*time.Second)), "a single trial request")
+	b.record(unavailable, start.Add(62*time.Second))
+	assert.Equal(t, circuitOpen, b.status().state, "trial failed")
+
+	assert.True(t, b.allow(start.Add(2*time.Minute+2*time.Second)))
+	b.record(nil, start.Add(2*time.Minute+2*time.Second))
+	assert.Equal(t, circuitClosed, b.status().state, "trial succeeded")
+	assert.Equal(t, 0, b.status().failures)
+}
+
+func TestDisabledCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		b.record(&burrow.Error{StatusCode: 503}, time.Now())
+	}
+	assert.True(t, b.allow(time.Now()))
+
+	var none *circuitBreaker
+	none.record(&burrow.Error{StatusCode: 503}, time.Now())
+	assert.True(t, none.allow(time.Now()))
+	assert.Equal(t, circuitClosed, none.status().state)
+}
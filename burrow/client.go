@@ -0,0 +1,252 @@
+// Package burrow is a client for the v3 HTTP API of Burrow, the Kafka consumer lag monitor (https://github.com/linkedin/Burrow).
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// tracerName is the name of the tracer of the spans of the requests to Burrow.
+const tracerName = "github.com/Financial-Times/kafka-lagcheck/burrow"
+
+// Attributes of the spans of the requests to Burrow, besides the HTTP ones.
+const (
+	ClusterKey       = attribute.Key("kafka.cluster")
+	ConsumerGroupKey = attribute.Key("kafka.consumer_group")
+	TopicKey         = attribute.Key("kafka.topic")
+)
+
+// Error is returned when Burrow answers with a non 200 status or with a response flagged as an error.
+type Error struct {
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Burrow returned status %d for %s", e.StatusCode, e.URL)
+	}
+	return fmt.Sprintf("Burrow returned status %d for %s: %s", e.StatusCode, e.URL, e.Message)
+}
+
+// Client makes requests to the Burrow v3 API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the Client use the given http.Client for all its requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets the time limit of every request made by the Client, including reading the response body.
+// It is applied to the http.Client in use, so it should come after WithHTTPClient.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		httpClient := *c.httpClient
+		httpClient.Timeout = timeout
+		c.httpClient = &httpClient
+	}
+}
+
+// NewClient creates a Client for the Burrow instance at baseURL (e.g. http://burrow:8080/__burrow).
+// Requests time out after 10 seconds unless configured otherwise.
+func NewClient(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// BaseURL returns the URL of the Burrow instance the Client makes requests to.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Clusters lists the names of the Kafka clusters monitored by Burrow.
+func (c *Client) Clusters(ctx context.Context) ([]string, error) {
+	var resp clusterListResponse
+	if err := c.get(ctx, "Clusters", "/v3/kafka", &resp); err != nil {
+		return nil, err
+	}
+	if resp.Clusters == nil {
+		return nil, errors.New("Cluster list response has no clusters")
+	}
+	return resp.Clusters, nil
+}
+
+// Cluster returns the configuration of a Kafka cluster.
+func (c *Client) Cluster(ctx context.Context, cluster string) (*ClusterDetail, error) {
+	var resp clusterDetailResponse
+	if err := c.get(ctx, "Cluster", clusterPath(cluster), &resp, ClusterKey.String(cluster)); err != nil {
+		return nil, err
+	}
+	if resp.Module == nil {
+		return nil, fmt.Errorf("Cluster detail response for %s has no module", cluster)
+	}
+	return resp.Module, nil
+}
+
+// Consumers lists the consumer groups of a Kafka cluster.
+func (c *Client) Consumers(ctx context.Context, cluster string) ([]string, error) {
+	var resp consumerListResponse
+	if err := c.get(ctx, "Consumers", clusterPath(cluster)+"/consumer", &resp, ClusterKey.String(cluster)); err != nil {
+		return nil, err
+	}
+	if resp.Consumers == nil {
+		return nil, fmt.Errorf("Consumer list response for cluster %s has no consumers", cluster)
+	}
+	return resp.Consumers, nil
+}
+
+// ConsumerStatus returns the evaluation of a consumer group, listing only its partitions that are not OK.
+func (c *Client) ConsumerStatus(ctx context.Context, cluster string, group string) (*ConsumerGroupStatus, error) {
+	return c.consumerStatus(ctx, "ConsumerStatus", cluster, group, consumerPath(cluster, group)+"/status")
+}
+
+// ConsumerLag returns the evaluation of a consumer group, listing all of its partitions.
+func (c *Client) ConsumerLag(ctx context.Context, cluster string, group string) (*ConsumerGroupStatus, error) {
+	return c.consumerStatus(ctx, "ConsumerLag", cluster, group, consumerPath(cluster, group)+"/lag")
+}
+
+func (c *Client) consumerStatus(ctx context.Context, operation string, cluster string, group string, path string) (*ConsumerGroupStatus, error) {
+	var resp consumerStatusResponse
+	if err := c.get(ctx, operation, path, &resp, ClusterKey.String(cluster), ConsumerGroupKey.String(group)); err != nil {
+		return nil, err
+	}
+	if resp.Status == nil {
+		return nil, fmt.Errorf("Consumer status response from %s has no status", path)
+	}
+	if resp.Status.Status == "" {
+		return nil, fmt.Errorf("Consumer status response from %s has an empty status", path)
+	}
+	return resp.Status, nil
+}
+
+// ConsumerDetail returns the offsets Burrow stored for a consumer group.
+func (c *Client) ConsumerDetail(ctx context.Context, cluster string, group string) (ConsumerDetail, error) {
+	var resp consumerDetailResponse
+	if err := c.get(ctx, "ConsumerDetail", consumerPath(cluster, group), &resp, ClusterKey.String(cluster), ConsumerGroupKey.String(group)); err != nil {
+		return nil, err
+	}
+	if resp.Topics == nil {
+		return nil, fmt.Errorf("Consumer detail response for %s has no topics", group)
+	}
+	return resp.Topics, nil
+}
+
+// Topics lists the topics of a Kafka cluster.
+func (c *Client) Topics(ctx context.Context, cluster string) ([]string, error) {
+	var resp topicListResponse
+	if err := c.get(ctx, "Topics", clusterPath(cluster)+"/topic", &resp, ClusterKey.String(cluster)); err != nil {
+		return nil, err
+	}
+	if resp.Topics == nil {
+		return nil, fmt.Errorf("Topic list response for cluster %s has no topics", cluster)
+	}
+	return resp.Topics, nil
+}
+
+// TopicOffsets returns the log end offset of every partition of a topic, indexed by partition number.
+func (c *Client) TopicOffsets(ctx context.Context, cluster string, topic string) ([]int64, error) {
+	var resp topicDetailResponse
+	if err := c.get(ctx, "TopicOffsets", clusterPath(cluster)+"/topic/"+url.PathEscape(topic), &resp, ClusterKey.String(cluster), TopicKey.String(topic)); err != nil {
+		return nil, err
+	}
+	if resp.Offsets == nil {
+		return nil, fmt.Errorf("Topic detail response for %s has no offsets", topic)
+	}
+	return resp.Offsets, nil
+}
+
+func clusterPath(cluster string) string {
+	return "/v3/kafka/" + url.PathEscape(cluster)
+}
+
+func consumerPath(cluster string, group string) string {
+	return clusterPath(cluster) + "/consumer/" + url.PathEscape(group)
+}
+
+type errorResponse interface {
+	burrowError() (bool, string)
+}
+
+func (r response) burrowError() (bool, string) {
+	return r.Error, r.Message
+}
+
+// get makes a request to Burrow in a span named after the operation, propagating the trace context of ctx to Burrow.
+func (c *Client) get(ctx context.Context, operation string, path string, v errorResponse, attrs ...attribute.KeyValue) (err error) {
+	u := c.baseURL + path
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Burrow "+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, semconv.HTTPRequestMethodGet, semconv.URLFull(u))...))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("Could not create request to %s: %v", u, err)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Could not execute request to burrow: %w", err)
+	}
+	defer func() {
+		// drained so that the connection can be reused even if the body couldn't be read to the end
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	span.SetAttributes(semconv.HTTPResponseBodySize(len(body)))
+	if err != nil {
+		return fmt.Errorf("Could not read response body from %s: %w", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp response
+		json.Unmarshal(body, &errResp)
+		return &Error{URL: u, StatusCode: resp.StatusCode, Message: errResp.Message}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Could not decode response body of %d bytes from %s to json: %v", len(body), u, err)
+	}
+	if isError, message := v.burrowError(); isError {
+		return &Error{URL: u, StatusCode: resp.StatusCode, Message: message}
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package burrow
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestServer(routes map[string]string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, found := routes[r.URL.EscapedPath()]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":true,"message":"cluster or consumer not found","request":{}}`))
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestClusterAndTopicEndpoints(t *testing.T) {
+	server := newTestServer(map[string]string{
+		"/__burrow/v3/kafka":                     `{"error":false,"message":"cluster list returned","clusters":["local","remote"]}`,
+		"/__burrow/v3/kafka/local":               `{"error":false,"message":"cluster module detail returned","module":{"class-name":"kafka","servers":["kafka:9092"],"client-profile":{"name":"default","client-id":"burrow","kafka-version":"0.10.2"},"topic-refresh":60,"offset-refresh":30}}`,
+		"/__burrow/v3/kafka/local/consumer":      `{"error":false,"message":"consumer list returned","consumers":["group-a","group-b"]}`,
+		"/__burrow/v3/kafka/local/topic":         `{"error":false,"message":"topic list returned","topics":["CmsPublicationEvents"]}`,
+		"/__burrow/v3/kafka/local/topic/Concept": `{"error":false,"message":"topic offsets returned","offsets":[100,250]}`,
+	}, http.StatusOK)
+	defer server.Close()
+
+	c := NewClient(server.URL + "/__burrow/")
+	ctx := context.Background()
+
+	clusters, err := c.Clusters(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"local", "remote"}, clusters)
+
+	cluster, err := c.Cluster(ctx, "local")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kafka:9092"}, cluster.Servers)
+	assert.Equal(t, "0.10.2", cluster.ClientProfile.KafkaVersion)
+
+	consumers, err := c.Consumers(ctx, "local")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group-a", "group-b"}, consumers)
+
+	topics, err := c.Topics(ctx, "local")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"CmsPublicationEvents"}, topics)
+
+	offsets, err := c.TopicOffsets(ctx, "local", "Concept")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{100, 250}, offsets)
+
+	_, err = c.Consumers(ctx, "unknown")
+	assert.EqualError(t, err, "Burrow returned status 404 for "+server.URL+"/__burrow/v3/kafka/unknown/consumer: cluster or consumer not found")
+	burrowErr, ok := err.(*Error)
+	assert.True(t, ok, "error type")
+	assert.Equal(t, http.StatusNotFound, burrowErr.StatusCode)
+}
+
+func TestConsumerEndpoints(t *testing.T) {
+	server := newTestServer(map[string]string{
+		"/v3/kafka/local/consumer/group-a/status": `{
+			"error": false,
+			"message": "consumer status returned",
+			"status": {
+				"cluster": "local",
+				"group": "group-a",
+				"status": "WARN",
+				"complete": 0.5,
+				"partitions": [
+					{
+						"topic": "CmsPublicationEvents",
+						"partition": 1,
+						"owner": "/10.2.1.5",
+						"client_id": "consumer-1",
+						"status": "WARN",
+						"start": {"offset": 100, "timestamp": 1474992081559, "lag": 8},
+						"end": {"offset": 120, "timestamp": 1474992621559, "lag": 19},
+						"current_lag": 25,
+						"complete": 1
+					}
+				],
+				"partition_count": 2,
+				"maxlag": {
+					"topic": "CmsPublicationEvents",
+					"partition": 1,
+					"status": "WARN",
+					"end": {"offset": 120, "timestamp": 1474992621559, "lag": 19}
+				},
+				"totallag": 25
+			}
+		}`,
+		"/v3/kafka/local/consumer/group-a/lag": `{
+			"error": false,
+			"message": "consumer status returned",
+			"status": {"cluster": "local", "group": "group-a", "status": "OK", "complete": true, "partitions": [], "totallag": 0}
+		}`,
+		"/v3/kafka/local/consumer/group-a": `{
+			"error": false,
+			"message": "consumer detail returned",
+			"topics": {
+				"CmsPublicationEvents": [
+					{"offsets": [{"offset": 100, "timestamp": 1474992081559, "lag": 8}, null], "owner": "/10.2.1.5", "client_id": "consumer-1", "current-lag": 25}
+				]
+			}
+		}`,
+	}, http.StatusOK)
+	defer server.Close()
+
+	c := NewClient(server.URL)
+	ctx := context.Background()
+
+	status, err := c.ConsumerStatus(ctx, "local", "group-a")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusWarning, status.Status)
+	assert.Equal(t, Completeness(0.5), status.Complete)
+	assert.Equal(t, int64(25), status.TotalLag)
+	assert.Equal(t, 2, status.PartitionCount)
+	assert.Len(t, status.Partitions, 1)
+	assert.Equal(t, "/10.2.1.5", status.Partitions[0].Owner)
+	assert.Equal(t, int64(120), status.Partitions[0].End.Offset)
+	assert.Equal(t, int64(25), status.Partitions[0].Lag())
+	assert.Equal(t, int64(19), status.MaxLag.Lag(), "lag falls back to the end offset lag")
+
+	lag, err := c.ConsumerLag(ctx, "local", "group-a")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusOK, lag.Status)
+	assert.Equal(t, Completeness(1), lag.Complete, "boolean completeness")
+
+	detail, err := c.ConsumerDetail(ctx, "local", "group-a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), detail["CmsPublicationEvents"][0].CurrentLag)
+	assert.Equal(t, int64(100), detail["CmsPublicationEvents"][0].Offsets[0].Offset)
+	assert.Nil(t, detail["CmsPublicationEvents"][0].Offsets[1])
+}
+
+func TestInvalidResponses(t *testing.T) {
+	testCases := []struct {
+		body string
+		err  string
+	}{
+		{
+			body: ``,
+			err:  "Could not decode response body of 0 bytes from {url} to json: unexpected end of JSON input",
+		},
+		{
+			body: `{}`,
+			err:  "Consumer status response from /v3/kafka/local/consumer/group/status has no status",
+		},
+		{
+			body: `{"error": true}`,
+			err:  "Burrow returned status 200 for {url}",
+		},
+		{
+			body: `{"error": true, "message": "consumer group not found"}`,
+			err:  "Burrow returned status 200 for {url}: consumer group not found",
+		},
+		{
+			body: `{"error": null}`,
+			err:  "Consumer status response from /v3/kafka/local/consumer/group/status has no status",
+		},
+		{
+			body: `{"error": false}`,
+			err:  "Consumer status response from /v3/kafka/local/consumer/group/status has no status",
+		},
+		{
+			body: `{"error": false, "status": {}}`,
+			err:  "Consumer status response from /v3/kafka/local/consumer/group/status has an empty status",
+		},
+		{
+			body: `{"error": false, "status": {"status": 1, "totallag": 3}}`,
+			err:  "Could not decode response body of 56 bytes from {url} to json: json: cannot unmarshal number",
+		},
+		{
+			body: `{"error": false, "status": {"status": 1, "totallag": "hi"}}`,
+			err:  "Could not decode response body of 59 bytes from {url} to json: json: cannot unmarshal number",
+		},
+		{
+			body: `{"error": false, "status": {"status": "any string", "totallag": "hi"}}`,
+			err:  "Could not decode response body of 70 bytes from {url} to json: json: cannot unmarshal string",
+		},
+		{
+			body: `{"error": false, "status": {"status": "OK", "complete": "yes"}}`,
+			err:  "Could not decode response body of 63 bytes from {url} to json: complete is neither a boolean nor a number: \"yes\"",
+		},
+	}
+
+	for _, tc := range testCases {
+		server := newTestServer(map[string]string{"/v3/kafka/local/consumer/group/status": tc.body}, http.StatusOK)
+		url := server.URL + "/v3/kafka/local/consumer/group/status"
+		_, err := NewClient(server.URL).ConsumerStatus(context.Background(), "local", "group")
+		if assert.Error(t, err) {
+			expected := strings.Replace(tc.err, "{url}", url, 1)
+			assert.True(t, strings.HasPrefix(err.Error(), expected), "Expected to start with: [%s]\nActual: [%s]", expected, err.Error())
+		}
+		server.Close()
+	}
+}
+
+func TestTimeoutAndCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := NewClient(server.URL, WithHTTPClient(&http.Client{}), WithTimeout(50*time.Millisecond))
+	start := time.Now()
+	_, err := c.Clusters(context.Background())
+	assert.Error(t, err, "timed out request")
+	assert.True(t, time.Since(start) < time.Second, "request should time out quickly")
+
+	c = NewClient(server.URL, WithTimeout(time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = c.Clusters(ctx)
+	assert.Error(t, err, "cancelled request")
+	assert.True(t, time.Since(start) < time.Second, "request should be cancelled quickly")
+}
+
+func TestPartitionTimeLag(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	millis := func(d time.Duration) int64 {
+		return now.Add(-d).UnixNano() / int64(time.Millisecond)
+	}
+	lag := func(l int64) *int64 {
+		return &l
+	}
+
+	var testCases = []struct {
+		description string
+		partition   PartitionStatus
+		expected    time.Duration
+	}{
+		{
+			description: "no lag",
+			partition: PartitionStatus{
+				Start:      &Offset{Offset: 100, Timestamp: millis(10 * time.Minute)},
+				End:        &Offset{Offset: 100, Timestamp: millis(10 * time.Minute)},
+				CurrentLag: lag(0),
+			},
+			expected: 0,
+		},
+		{
+			description: "consuming 1 message per second with 120 messages behind, last commit 10s ago",
+			partition: PartitionStatus{
+				Start:      &Offset{Offset: 100, Timestamp: millis(70 * time.Second)},
+				End:        &Offset{Offset: 160, Timestamp: millis(10 * time.Second)},
+				CurrentLag: lag(120),
+			},
+			expected: 130 * time.Second,
+		},
+		{
+			description: "no progress during the window",
+			partition: PartitionStatus{
+				Start: &Offset{Offset: 100, Timestamp: millis(15 * time.Minute)},
+				End:   &Offset{Offset: 100, Timestamp: millis(5 * time.Minute), Lag: 8},
+			},
+			expected: 15 * time.Minute,
+		},
+		{
+			description: "no offsets",
+			partition:   PartitionStatus{CurrentLag: lag(8)},
+			expected:    0,
+		},
+		{
+			description: "consuming 1 message in 10 minutes with billions of messages behind",
+			partition: PartitionStatus{
+				Start:      &Offset{Offset: 100, Timestamp: millis(10 * time.Minute)},
+				End:        &Offset{Offset: 101, Timestamp: millis(0)},
+				CurrentLag: lag(1 << 40),
+			},
+			expected: math.MaxInt64,
+		},
+		{
+			description: "consuming 1 message in 10 minutes with millions of messages behind, last commit years ago",
+			partition: PartitionStatus{
+				Start:      &Offset{Offset: 100, Timestamp: millis(10*365*24*time.Hour + 10*time.Minute)},
+				End:        &Offset{Offset: 101, Timestamp: millis(10 * 365 * 24 * time.Hour)},
+				CurrentLag: lag(15000000),
+			},
+			expected: math.MaxInt64,
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.partition.TimeLag(now), tc.description)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	var traceparent string
+	body := `{"error":false,"message":"consumer status returned","status":{"status":"OK","complete":true,"totallag":3}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if r.URL.Path != "/v3/kafka/local/consumer/content-notifications/lag" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":true,"message":"cluster or consumer not found"}`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "poll")
+	_, err := client.ConsumerLag(ctx, "local", "content-notifications")
+	assert.NoError(t, err)
+	_, err = client.ConsumerLag(ctx, "local", "unknown")
+	assert.Error(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 3) {
+		span := spans[0]
+		assert.Equal(t, "Burrow ConsumerLag", span.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		attrs := attribute.NewSet(span.Attributes()...)
+		for key, expected := range map[attribute.Key]attribute.Value{
+			ClusterKey:                  attribute.StringValue("local"),
+			ConsumerGroupKey:            attribute.StringValue("content-notifications"),
+			"http.request.method":       attribute.StringValue("GET"),
+			"url.full":                  attribute.StringValue(server.URL + "/v3/kafka/local/consumer/content-notifications/lag"),
+			"http.response.status_code": attribute.IntValue(200),
+			"http.response.body.size":   attribute.IntValue(len(body)),
+		} {
+			value, _ := attrs.Value(key)
+			assert.Equal(t, expected, value, string(key))
+		}
+		assert.Equal(t, codes.Unset, span.Status().Code)
+
+		failed := spans[1]
+		failedAttrs := attribute.NewSet(failed.Attributes()...)
+		status, _ := failedAttrs.Value("http.response.status_code")
+		assert.Equal(t, int64(404), status.AsInt64())
+		assert.Equal(t, codes.Error, failed.Status().Code)
+		assert.Equal(t, "00-"+parent.SpanContext().TraceID().String()+"-"+failed.SpanContext().SpanID().String()+"-01", traceparent, "trace context propagated")
+	}
+}
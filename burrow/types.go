@@ -0,0 +1,189 @@
+package burrow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Status is the evaluation status Burrow gives to a consumer group or a partition.
+type Status string
+
+// Statuses returned by the Burrow v3 API, see https://github.com/linkedin/Burrow/wiki/Consumer-Lag-Evaluation-Rules
+const (
+	StatusNotFound Status = "NOTFOUND"
+	StatusOK       Status = "OK"
+	StatusWarning  Status = "WARN"
+	StatusError    Status = "ERR"
+	StatusStop     Status = "STOP"
+	StatusStall    Status = "STALL"
+	StatusRewind   Status = "REWIND"
+)
+
+// Completeness is the ratio of offsets Burrow has stored for a consumer group compared to the number it needs for a
+// full evaluation, between 0 and 1. Older Burrow versions report it as a boolean, which is decoded as 0 or 1.
+type Completeness float64
+
+// UnmarshalJSON accepts both the numeric and the boolean representation.
+func (c *Completeness) UnmarshalJSON(data []byte) error {
+	var complete bool
+	if err := json.Unmarshal(data, &complete); err == nil {
+		if complete {
+			*c = 1
+		} else {
+			*c = 0
+		}
+		return nil
+	}
+	var ratio float64
+	if err := json.Unmarshal(data, &ratio); err != nil {
+		return fmt.Errorf("complete is neither a boolean nor a number: %s", string(data))
+	}
+	*c = Completeness(ratio)
+	return nil
+}
+
+// Offset is a committed consumer offset together with the lag at the time it was committed.
+type Offset struct {
+	Offset     int64 `json:"offset"`
+	Timestamp  int64 `json:"timestamp"`
+	ObservedAt int64 `json:"observedAt"`
+	Lag        int64 `json:"lag"`
+}
+
+// PartitionStatus is the evaluation of a consumer group on a single topic partition.
+type PartitionStatus struct {
+	Topic     string       `json:"topic"`
+	Partition int32        `json:"partition"`
+	Owner     string       `json:"owner"`
+	ClientID  string       `json:"client_id"`
+	Status    Status       `json:"status"`
+	Start     *Offset      `json:"start"`
+	End       *Offset      `json:"end"`
+	Complete  Completeness `json:"complete"`
+	// CurrentLag is nil for Burrow versions that don't report it.
+	CurrentLag *int64 `json:"current_lag"`
+}
+
+// Lag returns the current lag of the partition, falling back to the lag at the last evaluated offset
+// when Burrow doesn't report the current one.
+func (p PartitionStatus) Lag() int64 {
+	if p.CurrentLag != nil {
+		return *p.CurrentLag
+	}
+	if p.End != nil {
+		return p.End.Lag
+	}
+	return 0
+}
+
+// TimeLag estimates how far behind in time the consumer of the partition is at now. It is the time the consumer needs
+// to catch up with its current lag at the rate it consumed between the start and the end of Burrow's evaluation window,
+// plus the time since its last commit. If the consumer made no progress during the window, it is the time since the start
+// of the window. A partition without lag is never behind. A consumer so slow that it wouldn't catch up before the end of
+// time is behind by the maximum duration.
+func (p PartitionStatus) TimeLag(now time.Time) time.Duration {
+	lag := p.Lag()
+	if lag <= 0 || p.Start == nil || p.End == nil {
+		return 0
+	}
+	if p.End.Offset <= p.Start.Offset || p.End.Timestamp <= p.Start.Timestamp {
+		return now.Sub(millisToTime(p.Start.Timestamp))
+	}
+	rate := float64(p.End.Offset-p.Start.Offset) / float64(p.End.Timestamp-p.Start.Timestamp)
+	catchUp := float64(lag) / rate * float64(time.Millisecond)
+	if catchUp >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	sinceLastCommit := now.Sub(millisToTime(p.End.Timestamp))
+	if sinceLastCommit < 0 {
+		sinceLastCommit = 0
+	}
+	if time.Duration(catchUp) > math.MaxInt64-sinceLastCommit {
+		return math.MaxInt64
+	}
+	return time.Duration(catchUp) + sinceLastCommit
+}
+
+func millisToTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+// ConsumerGroupStatus is the evaluation of a consumer group.
+// Partitions contains only the partitions that are not OK for the status endpoint, and all of them for the lag endpoint.
+type ConsumerGroupStatus struct {
+	Cluster        string            `json:"cluster"`
+	Group          string            `json:"group"`
+	Status         Status            `json:"status"`
+	Complete       Completeness      `json:"complete"`
+	Partitions     []PartitionStatus `json:"partitions"`
+	PartitionCount int               `json:"partition_count"`
+	MaxLag         *PartitionStatus  `json:"maxlag"`
+	TotalLag       int64             `json:"totallag"`
+}
+
+// ConsumerPartition holds the offsets Burrow stored for a consumer group on a single topic partition.
+type ConsumerPartition struct {
+	Offsets    []*Offset `json:"offsets"`
+	Owner      string    `json:"owner"`
+	ClientID   string    `json:"client_id"`
+	CurrentLag int64     `json:"current-lag"`
+}
+
+// ConsumerDetail maps every topic consumed by a consumer group to its partitions, indexed by partition number.
+type ConsumerDetail map[string][]ConsumerPartition
+
+// ClusterDetail is the configuration of a Kafka cluster monitored by Burrow.
+type ClusterDetail struct {
+	ClassName     string   `json:"class-name"`
+	Servers       []string `json:"servers"`
+	ClientProfile struct {
+		Name         string `json:"name"`
+		ClientID     string `json:"client-id"`
+		KafkaVersion string `json:"kafka-version"`
+	} `json:"client-profile"`
+	TopicRefresh  int64 `json:"topic-refresh"`
+	OffsetRefresh int64 `json:"offset-refresh"`
+}
+
+// response holds the fields common to all Burrow v3 responses.
+type response struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+}
+
+type clusterListResponse struct {
+	response
+	Clusters []string `json:"clusters"`
+}
+
+type clusterDetailResponse struct {
+	response
+	Module *ClusterDetail `json:"module"`
+}
+
+type consumerListResponse struct {
+	response
+	Consumers []string `json:"consumers"`
+}
+
+type consumerStatusResponse struct {
+	response
+	Status *ConsumerGroupStatus `json:"status"`
+}
+
+type consumerDetailResponse struct {
+	response
+	Topics ConsumerDetail `json:"topics"`
+}
+
+type topicListResponse struct {
+	response
+	Topics []string `json:"topics"`
+}
+
+type topicDetailResponse struct {
+	response
+	Offsets []int64 `json:"offsets"`
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Exit codes of the check command.
+const (
+	checkOK      = 0
+	checkLagging = 1
+	checkFailed  = 2
+)
+
+// Output formats of the check command.
+const (
+	checkFormatTable = "table"
+	checkFormatJSON  = "json"
+)
+
+// Statuses of the consumer groups printed by the check command.
+const (
+	groupOK       = "ok"
+	groupLagging  = "lagging"
+	groupSilenced = "silenced"
+	groupUnknown  = "unknown"
+	groupError    = "error"
+)
+
+// checkResult is the JSON output of the check command: the lag report served by /__lag, with the outcome of the check
+// as result (ok, lagging or error) and the status of every consumer group.
+type checkResult struct {
+	Result   string            `json:"result"`
+	Statuses map[string]string `json:"statuses"`
+	lagReport
+}
+
+// runCheck polls the lags once, prints them to out in the format and returns the exit code of the check command:
+// checkLagging if any consumer group is lagging, otherwise checkFailed if the lag of any couldn't be retrieved,
+// otherwise checkOK. Silenced consumer groups don't count.
+func runCheck(h *healthcheck, out io.Writer, format string) int {
+	h.poller.poll()
+	report := h.lagReport(h.poller.current())
+	code := checkCodeOf(report)
+	var err error
+	if format == checkFormatJSON {
+		err = printCheckJSON(out, report, code)
+	} else {
+		err = printCheckTable(out, report)
+	}
+	if err != nil {
+		logger.Error("Could not print the check result", "error", err)
+		return checkFailed
+	}
+	return code
+}
+
+func checkCodeOf(report lagReport) int {
+	code := checkOK
+	if report.Error != "" {
+		code = checkFailed
+	}
+	for _, cluster := range report.Clusters {
+		if cluster.Error != "" {
+			code = checkFailed
+		}
+		for _, group := range cluster.ConsumerGroups {
+			switch groupStatusOf(group) {
+			case groupLagging:
+				return checkLagging
+			case groupUnknown, groupError:
+				code = checkFailed
+			}
+		}
+	}
+	return code
+}
+
+// groupStatusOf tells whether the consumer group is lagging, or its lag couldn't be retrieved, whether in time
+// (unknown) or at all (error).
+func groupStatusOf(group consumerGroupReport) string {
+	switch {
+	case group.Unknown:
+		return groupUnknown
+	case group.Healthy:
+		return groupOK
+	case group.Silence != nil:
+		return groupSilenced
+	case group.Status == "":
+		return groupError
+	default:
+		return groupLagging
+	}
+}
+
+func printCheckJSON(out io.Writer, report lagReport, code int) error {
+	result := checkResult{Result: groupOK, Statuses: map[string]string{}, lagReport: report}
+	switch code {
+	case checkLagging:
+		result.Result = groupLagging
+	case checkFailed:
+		result.Result = groupError
+	}
+	for _, cluster := range report.Clusters {
+		for _, group := range cluster.ConsumerGroups {
+			result.Statuses[cluster.Cluster+"/"+group.ConsumerGroup] = groupStatusOf(group)
+		}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// printCheckTable prints a line per consumer group, sorted by cluster and name, with the topic it lags the most on.
+// The errors of the clusters whose consumer groups couldn't be listed are printed in place of their consumer groups.
+func printCheckTable(out io.Writer, report lagReport) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tCONSUMER GROUP\tSTATUS\tLAG\tTOPIC\tMESSAGE")
+	if report.Error != "" {
+		fmt.Fprintf(w, "-\t-\t%s\t\t\t%s\n", groupError, report.Error)
+	}
+	clusters := append([]clusterReport{}, report.Clusters...)
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Cluster < clusters[j].Cluster
+	})
+	for _, cluster := range clusters {
+		if cluster.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t%s\t\t\t%s\n", cluster.Cluster, groupError, cluster.Error)
+			continue
+		}
+		groups := append([]consumerGroupReport{}, cluster.ConsumerGroups...)
+		sort.SliceStable(groups, func(i, j int) bool {
+			return groups[i].ConsumerGroup < groups[j].ConsumerGroup
+		})
+		for _, group := range groups {
+			lag := ""
+			if group.Status != "" {
+				lag = strconv.FormatInt(group.Lag, 10)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", cluster.Cluster, group.ConsumerGroup, groupStatusOf(group), lag, laggingTopicOf(group), group.Error)
+		}
+	}
+	return w.Flush()
+}
+
+// laggingTopicOf returns the checked topic the consumer group lags the most on, or "" if it doesn't lag.
+func laggingTopicOf(group consumerGroupReport) string {
+	var most topicReport
+	for _, topic := range group.Topics {
+		if !topic.Whitelisted && topic.Lag > most.Lag {
+			most = topic
+		}
+	}
+	return most.Topic
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCheckBurrowStandIn serves the consumer groups of the local cluster with the given lags on CmsPublicationEvents,
+// and fails the requests for the lag of the others.
+func newCheckBurrowStandIn(lags map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/kafka/local/consumer" {
+			w.Write([]byte(`{"error":false,"consumers":["content-notifications","concept-reader","console-consumer-2324"]}`))
+			return
+		}
+		group := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v3/kafka/local/consumer/"), "/lag")
+		lag, found := lags[group]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":true,"message":"consumer group not found"}`))
+			return
+		}
+		w.Write([]byte(`{"error":false,"status":{"status":"OK","complete":true,"partitions":[{"topic":"NativeCmsPublicationEvents","current_lag":1},{"topic":"CmsPublicationEvents","current_lag":` + strconv.Itoa(lag) + `}],"totallag":` + strconv.Itoa(lag+1) + `}}`))
+	}))
+}
+
+func newCheckTestHealthcheck(url string, selected ...string) *healthcheck {
+	var patterns []pattern
+	for _, raw := range selected {
+		p, err := parsePattern(raw)
+		if err != nil {
+			panic(err)
+		}
+		patterns = append(patterns, p)
+	}
+	filters := newTestFilters([]string{"NativeCmsPublicationEvents"}, []string{}).selecting(patterns)
+	return newHealthcheck(healthcheckOptions{source: burrow.NewClient(url), clusters: []string{"local"}, filters: filters, thresholds: newThresholds(5, 1, 0, nil)})
+}
+
+func TestRunCheck(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+
+	var testCases = []struct {
+		name     string
+		lags     map[string]int
+		selected []string
+		code     int
+	}{
+		{name: "ok", lags: map[string]int{"content-notifications": 0, "concept-reader": 3, "console-consumer-2324": 0}, code: checkOK},
+		{name: "lagging", lags: map[string]int{"content-notifications": 20, "concept-reader": 0, "console-consumer-2324": 0}, code: checkLagging},
+		{name: "lagging and unavailable", lags: map[string]int{"content-notifications": 20, "concept-reader": 0}, code: checkLagging},
+		{name: "unavailable", lags: map[string]int{"content-notifications": 0, "concept-reader": 0}, code: checkFailed},
+		{name: "lagging but not selected", lags: map[string]int{"content-notifications": 20, "concept-reader": 0}, selected: []string{"/^conc/"}, code: checkOK},
+		{name: "selected lagging", lags: map[string]int{"content-notifications": 20, "concept-reader": 0}, selected: []string{"content-*"}, code: checkLagging},
+	}
+
+	for _, tc := range testCases {
+		server := newCheckBurrowStandIn(tc.lags)
+		var out bytes.Buffer
+		code := runCheck(newCheckTestHealthcheck(server.URL, tc.selected...), &out, checkFormatTable)
+		server.Close()
+		assert.Equal(t, tc.code, code, tc.name)
+	}
+}
+
+func TestRunCheckBurrowUnavailable(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	assert.Equal(t, checkFailed, runCheck(newCheckTestHealthcheck(server.URL), &out, checkFormatTable))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Regexp(t, `^local\s+-\s+error\s+\S`, lines[1], "error of the cluster")
+	}
+}
+
+func TestRunCheckTable(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	server := newCheckBurrowStandIn(map[string]int{"content-notifications": 20, "concept-reader": 0})
+	defer server.Close()
+
+	var out bytes.Buffer
+	runCheck(newCheckTestHealthcheck(server.URL), &out, checkFormatTable)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 4) {
+		assert.Regexp(t, `^CLUSTER\s+CONSUMER GROUP\s+STATUS\s+LAG\s+TOPIC\s+MESSAGE$`, lines[0])
+		assert.Regexp(t, `^local\s+concept-reader\s+ok\s+0\s*$`, lines[1], "sorted by name")
+		assert.Regexp(t, `^local\s+console-consumer-2324\s+error\s+\S`, lines[2])
+		assert.Regexp(t, `^local\s+content-notifications\s+lagging\s+20\s+CmsPublicationEvents\s+content-notifications`, lines[3], "lag without the whitelisted topics")
+	}
+}
+
+func TestRunCheckJSON(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	server := newCheckBurrowStandIn(map[string]int{"content-notifications": 20, "concept-reader": 0, "console-consumer-2324": 0})
+	defer server.Close()
+
+	var out bytes.Buffer
+	assert.Equal(t, checkLagging, runCheck(newCheckTestHealthcheck(server.URL, "con*"), &out, checkFormatJSON))
+
+	var result struct {
+		Result   string            `json:"result"`
+		Statuses map[string]string `json:"statuses"`
+		Stale    bool              `json:"stale"`
+		Clusters []struct {
+			Cluster        string `json:"cluster"`
+			ConsumerGroups []struct {
+				ConsumerGroup string `json:"consumerGroup"`
+				Lag           int64  `json:"lag"`
+				TotalLag      int64  `json:"totalLag"`
+			} `json:"consumerGroups"`
+		} `json:"clusters"`
+	}
+	if !assert.NoError(t, json.Unmarshal(out.Bytes(), &result), out.String()) {
+		return
+	}
+	assert.Equal(t, "lagging", result.Result)
+	assert.Equal(t, map[string]string{"local/content-notifications": "lagging", "local/concept-reader": "ok", "local/console-consumer-2324": "ok"}, result.Statuses)
+	assert.False(t, result.Stale)
+	if assert.Len(t, result.Clusters, 1) {
+		assert.Equal(t, "local", result.Clusters[0].Cluster)
+		for _, group := range result.Clusters[0].ConsumerGroups {
+			if group.ConsumerGroup == "content-notifications" {
+				assert.Equal(t, int64(20), group.Lag)
+				assert.Equal(t, int64(21), group.TotalLag)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+const (
+	defaultBusinessImpact = "Will delay publishing on respective pipeline."
+	defaultPanicGuide     = "https://runbooks.in.ft.com/kafka-lagcheck"
+	defaultSummary        = "Consumer group {{.ConsumerGroup}} on cluster {{.Cluster}} is lagging. Further info at: __burrow/v3/kafka/{{.Cluster}}/consumer/{{.ConsumerGroup}}/lag and, per partition, at the /__lag endpoint of kafka-lagcheck."
+)
+
+var defaultSummaryTemplate = template.Must(template.New("technicalSummary").Parse(defaultSummary))
+
+// checkRule sets the metadata of the checks of the consumer groups matching it.
+// When both ConsumerGroup and Topic are set, a consumer group has to match both.
+type checkRule struct {
+	ConsumerGroup    *pattern         `yaml:"consumerGroup" json:"consumerGroup,omitempty"`
+	Topic            *pattern         `yaml:"topic" json:"topic,omitempty"`
+	Severity         uint8            `yaml:"severity" json:"severity,omitempty"`
+	BusinessImpact   string           `yaml:"businessImpact" json:"businessImpact,omitempty"`
+	PanicGuide       string           `yaml:"panicGuide" json:"panicGuide,omitempty"`
+	Team             string           `yaml:"team" json:"team,omitempty"`
+	TechnicalSummary *summaryTemplate `yaml:"technicalSummary" json:"technicalSummary,omitempty"`
+	Critical         bool             `yaml:"critical" json:"critical,omitempty"`
+}
+
+// summaryTemplate is a text/template rendering the technical summary of a check from a summaryData.
+type summaryTemplate struct {
+	raw      string
+	template *template.Template
+}
+
+// summaryData is what a technical summary template can refer to, e.g. "{{.ConsumerGroup}} is lagging on {{.Topic}}".
+type summaryData struct {
+	Cluster       string
+	ConsumerGroup string
+	Topic         string
+	Team          string
+}
+
+func (t *summaryTemplate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	parsed, err := template.New("technicalSummary").Option("missingkey=error").Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid technicalSummary template: %v", err)
+	}
+	if err := parsed.Execute(&bytes.Buffer{}, summaryData{}); err != nil {
+		return fmt.Errorf("invalid technicalSummary template: %v", err)
+	}
+	*t = summaryTemplate{raw: raw, template: parsed}
+	return nil
+}
+
+func (t summaryTemplate) MarshalText() ([]byte, error) {
+	return []byte(t.raw), nil
+}
+
+// checkMetadata is what a consumer group check reports besides its outcome. A zero severity means the one of the
+// consumer group's threshold.
+type checkMetadata struct {
+	severity         uint8
+	businessImpact   string
+	panicGuide       string
+	team             string
+	technicalSummary *template.Template
+	critical         bool
+}
+
+// summary renders the technical summary of the check of a consumer group, mentioning its team if known.
+func (m checkMetadata) summary(data summaryData) string {
+	data.Team = m.team
+	var buf bytes.Buffer
+	if err := m.technicalSummary.Execute(&buf, data); err != nil {
+		warnRepeated(repeatKey("summary", data.Cluster, data.ConsumerGroup), "Could not render the technical summary", "cluster", data.Cluster, "consumer_group", data.ConsumerGroup, "error", err)
+		buf.Reset()
+		defaultSummaryTemplate.Execute(&buf, data)
+	}
+	if m.team != "" {
+		buf.WriteString(" Owned by " + m.team + ".")
+	}
+	return buf.String()
+}
+
+// checkRules resolves the metadata of the check of a consumer group from the rules of the config file.
+// A nil *checkRules gives the default metadata to every consumer group.
+type checkRules struct {
+	rules []checkRule
+}
+
+func newCheckRules(rules []checkRule) *checkRules {
+	return &checkRules{rules: rules}
+}
+
+// forConsumerGroup returns the metadata of the first rule matching the consumer group and the topic it lags on.
+// Settings missing from the rule take their default value.
+func (c *checkRules) forConsumerGroup(consumerGroup string, topic string) checkMetadata {
+	result := checkMetadata{
+		businessImpact:   defaultBusinessImpact,
+		panicGuide:       defaultPanicGuide,
+		technicalSummary: defaultSummaryTemplate,
+	}
+	if c == nil {
+		return result
+	}
+	for _, rule := range c.rules {
+		if rule.ConsumerGroup != nil && !rule.ConsumerGroup.match(consumerGroup) {
+			continue
+		}
+		if rule.Topic != nil && !rule.Topic.match(topic) {
+			continue
+		}
+		result.severity = rule.Severity
+		result.team = rule.Team
+		result.critical = rule.Critical
+		if rule.BusinessImpact != "" {
+			result.businessImpact = rule.BusinessImpact
+		}
+		if rule.PanicGuide != "" {
+			result.panicGuide = rule.PanicGuide
+		}
+		if rule.TechnicalSummary != nil {
+			result.technicalSummary = rule.TechnicalSummary.template
+		}
+		return result
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRulesForConsumerGroup(t *testing.T) {
+	c, err := parseConfig([]byte(`
+checks:
+  - consumerGroup: image-*
+    severity: 3
+    team: images
+    businessImpact: Images won't be published.
+    panicGuide: https://runbooks.in.ft.com/image-publish
+    technicalSummary: "{{.ConsumerGroup}} is behind on {{.Topic}} in {{.Cluster}}, contact {{.Team}}."
+  - topic: /^Cms.*Events$/
+    team: content
+`))
+	assert.NoError(t, err)
+	rules := newCheckRules(c.Checks)
+
+	var testCases = []struct {
+		consumerGroup  string
+		topic          string
+		severity       uint8
+		businessImpact string
+		panicGuide     string
+		summary        string
+	}{
+		{
+			consumerGroup:  "image-publish",
+			topic:          "CmsPublicationEvents",
+			severity:       3,
+			businessImpact: "Images won't be published.",
+			panicGuide:     "https://runbooks.in.ft.com/image-publish",
+			summary:        "image-publish is behind on CmsPublicationEvents in local, contact images. Owned by images.",
+		},
+		{
+			consumerGroup:  "content-notifications",
+			topic:          "CmsPublicationEvents",
+			businessImpact: defaultBusinessImpact,
+			panicGuide:     defaultPanicGuide,
+			summary:        "Consumer group content-notifications on cluster local is lagging. Further info at: __burrow/v3/kafka/local/consumer/content-notifications/lag and, per partition, at the /__lag endpoint of kafka-lagcheck. Owned by content.",
+		},
+		{
+			consumerGroup:  "content-notifications",
+			topic:          "Concept",
+			businessImpact: defaultBusinessImpact,
+			panicGuide:     defaultPanicGuide,
+			summary:        "Consumer group content-notifications on cluster local is lagging. Further info at: __burrow/v3/kafka/local/consumer/content-notifications/lag and, per partition, at the /__lag endpoint of kafka-lagcheck.",
+		},
+	}
+
+	for _, tc := range testCases {
+		metadata := rules.forConsumerGroup(tc.consumerGroup, tc.topic)
+		assert.Equal(t, tc.severity, metadata.severity, tc.consumerGroup)
+		assert.Equal(t, tc.businessImpact, metadata.businessImpact, tc.consumerGroup)
+		assert.Equal(t, tc.panicGuide, metadata.panicGuide, tc.consumerGroup)
+		assert.Equal(t, tc.summary, metadata.summary(summaryData{Cluster: "local", ConsumerGroup: tc.consumerGroup, Topic: tc.topic}), tc.consumerGroup)
+	}
+}
+
+func TestNilCheckRules(t *testing.T) {
+	var rules *checkRules
+	metadata := rules.forConsumerGroup("image-publish", "CmsPublicationEvents")
+	assert.Equal(t, uint8(0), metadata.severity)
+	assert.Equal(t, defaultBusinessImpact, metadata.businessImpact)
+	assert.Equal(t, defaultPanicGuide, metadata.panicGuide)
+	assert.Equal(t, "", metadata.team)
+}
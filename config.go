@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// config is the content of the file passed with the config-file option, in YAML or JSON.
+// The json tags are only used to log what changed when it is reloaded.
+type config struct {
+	BurrowURL          string              `yaml:"burrowUrl" json:"burrowUrl"`
+	ConsumerGroups     consumerGroupFilter `yaml:"consumerGroups" json:"consumerGroups"`
+	Topics             patternSet          `yaml:"topics" json:"topics"`
+	BridgeEnvironments []string            `yaml:"bridgeEnvironments" json:"bridgeEnvironments"`
+	Thresholds         []thresholdRule     `yaml:"thresholds" json:"thresholds"`
+	Checks             []checkRule         `yaml:"checks" json:"checks"`
+	Webhooks           []*webhook          `yaml:"webhooks" json:"webhooks"`
+}
+
+// thresholdRule overrides the lag tolerances and the check severity of the consumer groups matching it.
+// When both ConsumerGroup and Topic are set, a consumer group has to match both.
+type thresholdRule struct {
+	ConsumerGroup   *pattern  `yaml:"consumerGroup" json:"consumerGroup,omitempty"`
+	Topic           *pattern  `yaml:"topic" json:"topic,omitempty"`
+	MaxLagTolerance *int      `yaml:"maxLagTolerance" json:"maxLagTolerance,omitempty"`
+	ErrLagTolerance *int      `yaml:"errLagTolerance" json:"errLagTolerance,omitempty"`
+	MaxTimeLag      *duration `yaml:"maxTimeLag" json:"maxTimeLag,omitempty"`
+	Severity        uint8     `yaml:"severity" json:"severity,omitempty"`
+}
+
+// duration is a time.Duration written as a string in the config file (e.g. "5m").
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func loadConfig(path string) (*config, error) {
+	if path == "" {
+		return &config{}, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file %s: %v", path, err)
+	}
+	return parseConfig(data)
+}
+
+func parseConfig(data []byte) (*config, error) {
+	c := &config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("Could not parse config file: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid config file: %v", err)
+	}
+	return c, nil
+}
+
+func (c *config) validate() error {
+	for i, rule := range c.Thresholds {
+		if rule.ConsumerGroup == nil && rule.Topic == nil {
+			return fmt.Errorf("threshold %d must have a consumerGroup or a topic", i+1)
+		}
+		if rule.MaxTimeLag != nil && *rule.MaxTimeLag < 0 {
+			return fmt.Errorf("threshold %d has a negative maxTimeLag", i+1)
+		}
+		if rule.Severity > 3 {
+			return fmt.Errorf("threshold %d has severity %d, it must be between 1 and 3", i+1, rule.Severity)
+		}
+	}
+	for i, rule := range c.Checks {
+		if rule.ConsumerGroup == nil && rule.Topic == nil {
+			return fmt.Errorf("check %d must have a consumerGroup or a topic", i+1)
+		}
+		if rule.Severity > 3 {
+			return fmt.Errorf("check %d has severity %d, it must be between 1 and 3", i+1, rule.Severity)
+		}
+	}
+	for i, hook := range c.Webhooks {
+		if hook.URL == nil {
+			return fmt.Errorf("webhook %d must have a url", i+1)
+		}
+		if hook.Format != "" && hook.Format != webhookGeneric && hook.Format != webhookSlack {
+			return fmt.Errorf("webhook %d has format %q, it must be %s or %s", i+1, hook.Format, webhookGeneric, webhookSlack)
+		}
+		for _, event := range hook.Events {
+			if !isNotificationEvent(event) {
+				return fmt.Errorf("webhook %d has event %q, it must be one of %s", i+1, event, strings.Join(notificationEvents, ", "))
+			}
+		}
+		if hook.RenotifyInterval != nil && *hook.RenotifyInterval < 0 {
+			return fmt.Errorf("webhook %d has a negative renotifyInterval", i+1)
+		}
+	}
+	return nil
+}
+
+// threshold is the lag tolerance applied to a consumer group, see checkConsumerGroupForLags.
+// A negative message tolerance disables the corresponding check, and so does a zero maxTimeLag.
+// byTopic is set when it comes from a rule with a topic, whose tolerances apply to the lag on each matching topic
+// rather than to the lag of the consumer group.
+type threshold struct {
+	maxLagTolerance int
+	errLagTolerance int
+	maxTimeLag      time.Duration
+	severity        uint8
+	byTopic         bool
+}
+
+// thresholds resolves the threshold of a consumer group from the rules of the config file,
+// falling back to the tolerances given as options.
+type thresholds struct {
+	defaults threshold
+	rules    []thresholdRule
+}
+
+func newThresholds(maxLagTolerance int, errLagTolerance int, maxTimeLag time.Duration, rules []thresholdRule) *thresholds {
+	return &thresholds{
+		defaults: threshold{maxLagTolerance: maxLagTolerance, errLagTolerance: errLagTolerance, maxTimeLag: maxTimeLag, severity: 1},
+		rules:    rules,
+	}
+}
+
+// forConsumerGroup returns the threshold of the first rule matching the consumer group and the topic, or of the first
+// rule without topic matching the consumer group if the topic is "". Settings missing from the rule take their default
+// value.
+func (t *thresholds) forConsumerGroup(consumerGroup string, topic string) threshold {
+	for _, rule := range t.rules {
+		if rule.ConsumerGroup != nil && !rule.ConsumerGroup.match(consumerGroup) {
+			continue
+		}
+		if rule.Topic != nil && (topic == "" || !rule.Topic.match(topic)) {
+			continue
+		}
+		result := t.defaults
+		result.byTopic = rule.Topic != nil
+		if rule.MaxLagTolerance != nil {
+			result.maxLagTolerance = *rule.MaxLagTolerance
+		}
+		if rule.ErrLagTolerance != nil {
+			result.errLagTolerance = *rule.ErrLagTolerance
+		}
+		if rule.MaxTimeLag != nil {
+			result.maxTimeLag = time.Duration(*rule.MaxTimeLag)
+		}
+		if rule.Severity != 0 {
+			result.severity = rule.Severity
+		}
+		return result
+	}
+	return t.defaults
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	var testCases = []struct {
+		description string
+		data        string
+		err         string
+		rules       int
+	}{
+		{
+			description: "yaml",
+			data: `
+thresholds:
+  - consumerGroup: image-*
+    maxLagTolerance: 50000
+    severity: 3
+  - topic: /^Cms.*Events$/
+    errLagTolerance: 0
+`,
+			rules: 2,
+		},
+		{
+			description: "json",
+			data:        `{"thresholds": [{"consumerGroup": "content-notifications", "topic": "CmsPublicationEvents", "maxLagTolerance": 100}]}`,
+			rules:       1,
+		},
+		{
+			description: "empty",
+			data:        ``,
+			rules:       0,
+		},
+		{
+			description: "unknown field",
+			data:        `{"thresholds": [{"group": "content-notifications"}]}`,
+			err:         "Could not parse config file: yaml: unmarshal errors:\n  line 1: field group not found in type main.thresholdRule",
+		},
+		{
+			description: "invalid regular expression",
+			data:        `{"thresholds": [{"topic": "/Cms(/"}]}`,
+			err:         "Could not parse config file: invalid regular expression /Cms(/: error parsing regexp: missing closing ): `Cms(`",
+		},
+		{
+			description: "no matcher",
+			data:        `{"thresholds": [{"maxLagTolerance": 100}]}`,
+			err:         "Invalid config file: threshold 1 must have a consumerGroup or a topic",
+		},
+		{
+			description: "negative time lag",
+			data:        `{"thresholds": [{"topic": "Concept"}, {"topic": "Concept", "maxTimeLag": "-1m"}]}`,
+			err:         "Invalid config file: threshold 2 has a negative maxTimeLag",
+		},
+		{
+			description: "invalid time lag",
+			data:        `{"thresholds": [{"topic": "Concept", "maxTimeLag": "5 minutes"}]}`,
+			err:         "Could not parse config file: time: unknown unit \" minutes\" in duration \"5 minutes\"",
+		},
+		{
+			description: "severity out of range",
+			data:        `{"thresholds": [{"topic": "Concept", "severity": 4}]}`,
+			err:         "Invalid config file: threshold 1 has severity 4, it must be between 1 and 3",
+		},
+		{
+			description: "check without matcher",
+			data:        `{"checks": [{"team": "content"}]}`,
+			err:         "Invalid config file: check 1 must have a consumerGroup or a topic",
+		},
+		{
+			description: "check severity out of range",
+			data:        `{"checks": [{"consumerGroup": "image-*", "severity": 5}]}`,
+			err:         "Invalid config file: check 1 has severity 5, it must be between 1 and 3",
+		},
+		{
+			description: "invalid technical summary template",
+			data:        `{"checks": [{"consumerGroup": "image-*", "technicalSummary": "{{.ConsumerGroup"}]}`,
+			err:         "Could not parse config file: invalid technicalSummary template: template: technicalSummary:1: unclosed action",
+		},
+		{
+			description: "unknown technical summary field",
+			data:        `{"checks": [{"consumerGroup": "image-*", "technicalSummary": "{{.Owner}}"}]}`,
+			err:         "Could not parse config file: invalid technicalSummary template: template: technicalSummary:1:2: executing \"technicalSummary\" at <.Owner>: can't evaluate field Owner in type main.summaryData",
+		},
+		{
+			description: "webhooks",
+			data: `
+webhooks:
+  - url: https://hooks.slack.com/services/T00/B00/secret
+    format: slack
+    events: [lagging, recovered]
+    renotifyInterval: 1h
+  - url: http://alerts.example.com/kafka
+    template: '{"text": {{json .Text}}}'
+`,
+		},
+		{
+			description: "webhook without url",
+			data:        `{"webhooks": [{"format": "slack"}]}`,
+			err:         "Invalid config file: webhook 1 must have a url",
+		},
+		{
+			description: "relative webhook url",
+			data:        `{"webhooks": [{"url": "/hooks/secret"}]}`,
+			err:         "Could not parse config file: invalid webhook url, it must be an absolute http or https URL",
+		},
+		{
+			description: "unknown webhook format",
+			data:        `{"webhooks": [{"url": "https://example.com", "format": "teams"}]}`,
+			err:         "Invalid config file: webhook 1 has format \"teams\", it must be generic or slack",
+		},
+		{
+			description: "unknown webhook event",
+			data:        `{"webhooks": [{"url": "https://example.com", "events": ["lagging", "stalled"]}]}`,
+			err:         "Invalid config file: webhook 1 has event \"stalled\", it must be one of lagging, recovered, burrow_unavailable, burrow_available",
+		},
+		{
+			description: "negative renotify interval",
+			data:        `{"webhooks": [{"url": "https://example.com", "renotifyInterval": "-1h"}]}`,
+			err:         "Invalid config file: webhook 1 has a negative renotifyInterval",
+		},
+		{
+			description: "unknown webhook template field",
+			data:        `{"webhooks": [{"url": "https://example.com", "template": "{{.Lag}}"}]}`,
+			err:         "Could not parse config file: invalid webhook template: template: template:1:2: executing \"template\" at <.Lag>: can't evaluate field Lag in type main.notification",
+		},
+	}
+
+	for _, tc := range testCases {
+		c, err := parseConfig([]byte(tc.data))
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.description)
+			continue
+		}
+		if assert.NoError(t, err, tc.description) {
+			assert.Len(t, c.Thresholds, tc.rules, tc.description)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	c, err := loadConfig("")
+	assert.NoError(t, err)
+	assert.Empty(t, c.Thresholds)
+
+	_, err = loadConfig("does-not-exist.yml")
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "kafka-lagcheck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	err = ioutil.WriteFile(path, []byte("thresholds:\n  - consumerGroup: image-*\n"), 0600)
+	assert.NoError(t, err)
+
+	c, err = loadConfig(path)
+	assert.NoError(t, err)
+	assert.Len(t, c.Thresholds, 1)
+}
+
+func TestThresholdsForConsumerGroup(t *testing.T) {
+	c, err := parseConfig([]byte(`
+thresholds:
+  - consumerGroup: content-notifications
+    topic: CmsPublicationEvents
+    maxLagTolerance: 100
+    severity: 1
+  - consumerGroup: image-*
+    maxLagTolerance: 50000
+    severity: 3
+  - topic: /^Cms.*Events$/
+    errLagTolerance: 0
+    maxTimeLag: 5m
+    severity: 2
+`))
+	assert.NoError(t, err)
+	th := newThresholds(1000, 30, 0, c.Thresholds)
+
+	var testCases = []struct {
+		consumerGroup string
+		topic         string
+		expected      threshold
+	}{
+		{
+			consumerGroup: "content-notifications",
+			topic:         "CmsPublicationEvents",
+			expected:      threshold{maxLagTolerance: 100, errLagTolerance: 30, severity: 1, byTopic: true},
+		},
+		{
+			consumerGroup: "content-notifications",
+			topic:         "CmsMetadataPublicationEvents",
+			expected:      threshold{maxLagTolerance: 1000, errLagTolerance: 0, maxTimeLag: 5 * time.Minute, severity: 2, byTopic: true},
+		},
+		{
+			consumerGroup: "image-publish",
+			topic:         "CmsPublicationEvents",
+			expected:      threshold{maxLagTolerance: 50000, errLagTolerance: 30, severity: 3},
+		},
+		{
+			consumerGroup: "content-notifications",
+			topic:         "Concept",
+			expected:      threshold{maxLagTolerance: 1000, errLagTolerance: 30, severity: 1},
+		},
+		{
+			consumerGroup: "image-publish",
+			topic:         "",
+			expected:      threshold{maxLagTolerance: 50000, errLagTolerance: 30, severity: 3},
+		},
+		{
+			consumerGroup: "content-notifications",
+			topic:         "",
+			expected:      threshold{maxLagTolerance: 1000, errLagTolerance: 30, severity: 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, th.forConsumerGroup(tc.consumerGroup, tc.topic), "%s on %s", tc.consumerGroup, tc.topic)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "sync"
+
+// orderedDeliveries runs deliveries in the background, each one after those previously started for the same
+// destination, so that a slow destination neither blocks the poller nor receives a recovery before the failure.
+// The zero value is ready to use.
+type orderedDeliveries struct {
+	mu sync.Mutex
+	// last is closed once the last delivery started for the destination is over.
+	last map[string]chan struct{}
+
+	running sync.WaitGroup
+}
+
+// start runs the delivery in the background once the previous ones for the destination are over.
+func (d *orderedDeliveries) start(destination string, deliver func()) {
+	d.mu.Lock()
+	if d.last == nil {
+		d.last = map[string]chan struct{}{}
+	}
+	previous := d.last[destination]
+	done := make(chan struct{})
+	d.last[destination] = done
+	d.running.Add(1)
+	d.mu.Unlock()
+
+	go func() {
+		defer d.running.Done()
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		deliver()
+	}()
+}
+
+// wait waits for the deliveries started to be over.
+func (d *orderedDeliveries) wait() {
+	d.running.Wait()
+}
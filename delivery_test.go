@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderedDeliveries(t *testing.T) {
+	var d orderedDeliveries
+	var mu sync.Mutex
+	var delivered []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, name)
+	}
+
+	blocked := make(chan struct{})
+	d.start("slow", func() {
+		<-blocked
+		record("slow 1")
+	})
+	d.start("slow", func() { record("slow 2") })
+	d.start("fast", func() { record("fast") })
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) == 1
+	}, time.Second, time.Millisecond, "a slow destination doesn't hold back the others")
+	close(blocked)
+	d.wait()
+	assert.Equal(t, []string{"fast", "slow 1", "slow 2"}, delivered)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+)
+
+// checkKind tells whether a check is about the lag of a consumer group or about Burrow being unavailable.
+type checkKind string
+
+const (
+	consumerGroupCheck checkKind = "consumer_group"
+	burrowCheck        checkKind = "burrow"
+)
+
+// servedCheck is a check served on /__health, with the cluster, consumer group, topic and team it is about. A consumer
+// group check without cluster is about a critical consumer group missing from all of them, and a Burrow check without
+// cluster about Burrow being unavailable as a whole, or the lags being out of date.
+type servedCheck struct {
+	check         fthealth.Check
+	kind          checkKind
+	cluster       string
+	consumerGroup string
+	topic         string
+	team          string
+	unknown       bool
+}
+
+// checkState is the outcome of a check of a snapshot for the notifiers. It is evaluated exactly like the check served
+// on /__health, so silences and the hysteresis apply.
+type checkState struct {
+	id            string
+	kind          checkKind
+	cluster       string
+	consumerGroup string
+	topic         string
+	team          string
+	severity      uint8
+	name          string
+	failing       bool
+	// unknown is set when the lag of the consumer group couldn't be fetched, the check then neither fails nor passes.
+	unknown bool
+	// message is the error of a failing check and the output of a passing one.
+	message          string
+	technicalSummary string
+	panicGuide       string
+	businessImpact   string
+}
+
+// key identifies the check across snapshots. Burrow being unavailable as a whole is a single check, whether the
+// circuit breaker is open, the cluster list couldn't be retrieved or the lags are out of date.
+func (s checkState) key() string {
+	if s.kind == burrowCheck && s.cluster == "" {
+		return checkID("burrow")
+	}
+	return s.id
+}
+
+// evaluation holds the states of all the checks of a snapshot.
+type evaluation struct {
+	at     time.Time
+	checks []checkState
+	// burrowDown is set when no cluster could be checked, and unavailable holds the clusters whose consumer groups
+	// couldn't be listed. The consumer groups missing from the evaluation because of either may still be there.
+	burrowDown  bool
+	unavailable map[string]bool
+}
+
+// covers tells whether a check missing from the evaluation is really gone, rather than just not evaluated. A critical
+// consumer group is looked for on all the clusters, so it is known to be there again only if none is unavailable.
+func (e *evaluation) covers(state checkState) bool {
+	switch {
+	case state.kind == burrowCheck && state.cluster == "":
+		return true
+	case state.cluster == "":
+		return !e.burrowDown && len(e.unavailable) == 0
+	}
+	return !e.burrowDown && !e.unavailable[state.cluster]
+}
+
+// evaluate runs the checks of the snapshot, the same as /__health with all consumer groups.
+func (h *healthcheck) evaluate(snapshot *lagSnapshot) *evaluation {
+	e := &evaluation{at: snapshot.fetchedAt, unavailable: map[string]bool{}}
+	for _, served := range h.servedChecksOf(snapshot, gtgAllGroups) {
+		state := newCheckState(served)
+		if state.kind == burrowCheck && state.failing {
+			if state.cluster == "" {
+				e.burrowDown = true
+			} else {
+				e.unavailable[state.cluster] = true
+			}
+		}
+		e.checks = append(e.checks, state)
+	}
+	return e
+}
+
+func newCheckState(served servedCheck) checkState {
+	check := served.check
+	state := checkState{
+		id:               check.ID,
+		kind:             served.kind,
+		cluster:          served.cluster,
+		consumerGroup:    served.consumerGroup,
+		topic:            served.topic,
+		team:             served.team,
+		severity:         check.Severity,
+		name:             check.Name,
+		unknown:          served.unknown,
+		technicalSummary: check.TechnicalSummary,
+		panicGuide:       check.PanicGuide,
+		businessImpact:   check.BusinessImpact,
+	}
+	output, err := check.Checker()
+	state.message = output
+	if err != nil {
+		state.failing = true
+		state.message = err.Error()
+	}
+	return state
+}
+
+// checkTransition is a check that started failing, or that passes again or isn't there anymore, with its latest known
+// state and when it started failing.
+type checkTransition struct {
+	key   string
+	state checkState
+	since time.Time
+}
+
+// failingCheck is a check failing since the given time.
+type failingCheck struct {
+	state checkState
+	since time.Time
+}
+
+// checkTracker follows the failing checks across evaluations for the notifier and the alertPusher. The checks whose
+// state is unknown, or that can't be evaluated because Burrow is unavailable, keep their previous state.
+type checkTracker struct {
+	failing map[string]*failingCheck
+}
+
+func newCheckTracker() *checkTracker {
+	return &checkTracker{failing: map[string]*failingCheck{}}
+}
+
+// observe updates the failing checks from the evaluation and returns the checks that changed state, in the order of
+// the evaluation followed by those that aren't there anymore sorted by key. A transition is a start of failure when
+// state.failing is set, and a recovery otherwise.
+func (t *checkTracker) observe(e *evaluation) []checkTransition {
+	var transitions []checkTransition
+	seen := map[string]bool{}
+	for _, state := range e.checks {
+		key := state.key()
+		seen[key] = true
+		if state.unknown {
+			continue
+		}
+		failing := t.failing[key]
+		switch {
+		case state.failing && failing == nil:
+			t.failing[key] = &failingCheck{state: state, since: e.at}
+			transitions = append(transitions, checkTransition{key: key, state: state, since: e.at})
+		case state.failing:
+			failing.state = state
+		case failing != nil:
+			delete(t.failing, key)
+			transitions = append(transitions, checkTransition{key: key, state: state, since: failing.since})
+		}
+	}
+	var gone []string
+	for key, failing := range t.failing {
+		if !seen[key] && e.covers(failing.state) {
+			gone = append(gone, key)
+		}
+	}
+	sort.Strings(gone)
+	for _, key := range gone {
+		failing := t.failing[key]
+		delete(t.failing, key)
+		// the check passes from now on, whatever its last state
+		failing.state.failing = false
+		transitions = append(transitions, checkTransition{key: key, state: failing.state, since: failing.since})
+	}
+	return transitions
+}
+
+// failingKeys returns the keys of the checks failing, sorted.
+func (t *checkTracker) failingKeys() []string {
+	var keys []string
+	for key := range t.failing {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// patternSet allows the names matching any of its Include patterns, or all names if there are none,
+// except the ones matching any of its Exclude patterns.
+type patternSet struct {
+	Include []pattern `yaml:"include" json:"include,omitempty"`
+	Exclude []pattern `yaml:"exclude" json:"exclude,omitempty"`
+}
+
+func (s patternSet) allows(name string) bool {
+	if matchesAny(s.Exclude, name) {
+		return false
+	}
+	return len(s.Include) == 0 || matchesAny(s.Include, name)
+}
+
+func matchesAny(patterns []pattern, name string) bool {
+	for _, p := range patterns {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// consumerGroupFilter is a patternSet with a list of consumer group names that are never checked.
+type consumerGroupFilter struct {
+	patternSet `yaml:",inline"`
+	Deny       []string `yaml:"deny" json:"deny,omitempty"`
+}
+
+// filters decides which consumer groups are checked, and on which topics their lag counts.
+//
+// A consumer group is checked unless, in this order:
+//  1. it is in the deny list,
+//  2. it matches an exclude pattern,
+//  3. there are include patterns and it matches none of them,
+//  4. it is a kafka bridge (its name contains "kafka-bridge") and its name doesn't start with one of the
+//     bridge environments, when there are any,
+//  5. consumer groups were selected, by the check command, and it matches none of them.
+//
+// The lag on a topic counts unless it matches an exclude pattern, or there are include patterns and it matches none.
+type filters struct {
+	deniedConsumerGroups map[string]bool
+	consumerGroups       patternSet
+	topics               patternSet
+	bridgeEnvs           []pattern
+	selected             []pattern
+}
+
+// newFilters merges the filters of the config file with the ones given as options:
+// the whitelisted topics are excluded, and the whitelisted environments are added to the bridge environments.
+func newFilters(whitelistedTopics []string, whitelistedEnvs []string, deniedConsumerGroups []string, conf *config) (*filters, error) {
+	f := &filters{
+		deniedConsumerGroups: map[string]bool{},
+		consumerGroups:       conf.ConsumerGroups.patternSet,
+		topics:               patternSet{Include: conf.Topics.Include, Exclude: append([]pattern{}, conf.Topics.Exclude...)},
+	}
+	for _, consumerGroup := range deniedConsumerGroups {
+		f.deniedConsumerGroups[consumerGroup] = true
+	}
+	for _, consumerGroup := range conf.ConsumerGroups.Deny {
+		f.deniedConsumerGroups[consumerGroup] = true
+	}
+
+	for _, raw := range whitelistedTopics {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		p, err := parsePattern(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid whitelisted topic: %v", err)
+		}
+		f.topics.Exclude = append(f.topics.Exclude, p)
+	}
+
+	envs := append(append([]string{}, whitelistedEnvs...), conf.BridgeEnvironments...)
+	for _, raw := range envs {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		p, err := parseEnvironmentPattern(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid whitelisted environment: %v", err)
+		}
+		f.bridgeEnvs = append(f.bridgeEnvs, p)
+	}
+	return f, nil
+}
+
+// parseEnvironmentPattern parses a pattern matching the beginning of kafka bridge names:
+// "prod-*" matches "prod-uk-kafka-bridge", and so does "prod". Regular expressions are left as they are.
+func parseEnvironmentPattern(raw string) (pattern, error) {
+	p, err := parsePattern(raw)
+	if err != nil || p.regexp != nil {
+		return p, err
+	}
+	if !strings.HasSuffix(raw, "*") {
+		raw += "*"
+	}
+	return parsePattern(raw)
+}
+
+// selecting returns a copy of the filters checking only the consumer groups matching any of the patterns, among the
+// ones checked already. All of them are left if there are no patterns.
+func (f *filters) selecting(patterns []pattern) *filters {
+	selected := *f
+	selected.selected = patterns
+	return &selected
+}
+
+func (f *filters) checksConsumerGroup(consumerGroup string) bool {
+	if f.deniedConsumerGroups[consumerGroup] {
+		return false
+	}
+	if !f.consumerGroups.allows(consumerGroup) {
+		return false
+	}
+	if strings.Contains(consumerGroup, "kafka-bridge") && len(f.bridgeEnvs) > 0 && !matchesAny(f.bridgeEnvs, consumerGroup) {
+		return false
+	}
+	return len(f.selected) == 0 || matchesAny(f.selected, consumerGroup)
+}
+
+func (f *filters) checksTopic(topic string) bool {
+	return f.topics.allows(topic)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFiltersConsumerGroupPrecedence(t *testing.T) {
+	c, err := parseConfig([]byte(`
+consumerGroups:
+  include:
+    - xp-*
+    - /kafka-bridge/
+  exclude:
+    - /^xp-.*-blue$/
+    - console-consumer-*
+  deny:
+    - xp-notifications-push-2
+bridgeEnvironments:
+  - /^pub-prod-(eu|us)/
+`))
+	assert.NoError(t, err)
+	f, err := newFilters([]string{}, []string{"prod-uk"}, []string{"xp-v2-annotator-red"}, c)
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		consumerGroup string
+		checked       bool
+		reason        string
+	}{
+		{consumerGroup: "xp-notifications-push-1", checked: true, reason: "included"},
+		{consumerGroup: "xp-notifications-push-2", checked: false, reason: "denied in the config file although included"},
+		{consumerGroup: "xp-v2-annotator-red", checked: false, reason: "denied as option although included"},
+		{consumerGroup: "xp-v2-annotator-blue", checked: false, reason: "excluded although included"},
+		{consumerGroup: "console-consumer-2324", checked: false, reason: "excluded"},
+		{consumerGroup: "content-ingester", checked: false, reason: "not included"},
+		{consumerGroup: "prod-uk-kafka-bridge", checked: true, reason: "bridge from an environment given as option"},
+		{consumerGroup: "pub-prod-us-kafka-bridge", checked: true, reason: "bridge from an environment of the config file"},
+		{consumerGroup: "pre-prod-uk-kafka-bridge", checked: false, reason: "included but bridge from another environment"},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.checked, f.checksConsumerGroup(tc.consumerGroup), "%s: %s", tc.consumerGroup, tc.reason)
+	}
+}
+
+func TestFiltersTopics(t *testing.T) {
+	c, err := parseConfig([]byte(`
+topics:
+  include:
+    - /Events$/
+    - Concept*
+  exclude:
+    - /^Native/
+`))
+	assert.NoError(t, err)
+	f, err := newFilters([]string{"ConceptAnnotations", " NativeCmsPublicationEvents"}, []string{}, nil, c)
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		topic   string
+		checked bool
+	}{
+		{topic: "CmsPublicationEvents", checked: true},
+		{topic: "NativeCmsMetadataPublicationEvents", checked: false},
+		{topic: "Concept", checked: true},
+		{topic: "ConceptAnnotations", checked: false},
+		{topic: "PostPublicationMetadata", checked: false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.checked, f.checksTopic(tc.topic), tc.topic)
+	}
+	assert.Len(t, c.Topics.Exclude, 1, "whitelisted topics should not be added to the config")
+}
+
+func TestFiltersDefaults(t *testing.T) {
+	f, err := newFilters([]string{""}, []string{}, nil, &config{})
+	assert.NoError(t, err)
+	for _, name := range []string{"xp-notifications-push-2", "pre-prod-kafka-bridge"} {
+		assert.True(t, f.checksConsumerGroup(name), name)
+	}
+	assert.True(t, f.checksTopic("Concept"))
+}
+
+func TestInvalidFilters(t *testing.T) {
+	_, err := newFilters([]string{"/Concept(/"}, []string{}, nil, &config{})
+	assert.Error(t, err)
+
+	_, err = newFilters([]string{}, []string{"prod-["}, nil, &config{})
+	assert.Error(t, err)
+
+	_, err = parseConfig([]byte(`{"consumerGroups": {"include": ["/xp-(/"]}}`))
+	assert.Error(t, err)
+}
+
+func TestFiltersSelecting(t *testing.T) {
+	c, err := parseConfig([]byte(`
+consumerGroups:
+  exclude:
+    - console-consumer-*
+`))
+	assert.NoError(t, err)
+	f, err := newFilters([]string{}, []string{}, nil, c)
+	assert.NoError(t, err)
+	content, err := parsePattern("content-*")
+	assert.NoError(t, err)
+	console, err := parsePattern("/^console-/")
+	assert.NoError(t, err)
+
+	selected := f.selecting([]pattern{content, console})
+	assert.True(t, selected.checksConsumerGroup("content-notifications"), "selected")
+	assert.False(t, selected.checksConsumerGroup("concept-reader"), "not selected")
+	assert.False(t, selected.checksConsumerGroup("console-consumer-2324"), "selected but excluded")
+	assert.True(t, f.checksConsumerGroup("concept-reader"), "filters left unchanged")
+	assert.True(t, f.selecting(nil).checksConsumerGroup("concept-reader"), "all selected")
+}
@@ -0,0 +1,73 @@
+package main
+
+import "fmt"
+
+// gtgPolicy decides which consumer groups have to be healthy for the service to be good to go. Whatever the policy,
+// it isn't good to go while the lags are not available or out of date, or Burrow can't be reached.
+type gtgPolicy string
+
+const (
+	// gtgSelfOnly ignores the lags of all consumer groups.
+	gtgSelfOnly gtgPolicy = "self"
+	// gtgCriticalGroups requires the consumer groups marked as critical in the config file to be healthy.
+	gtgCriticalGroups gtgPolicy = "critical"
+	// gtgAllGroups requires all the consumer groups to be healthy.
+	gtgAllGroups gtgPolicy = "all"
+)
+
+func parseGTGPolicy(value string) (gtgPolicy, error) {
+	switch policy := gtgPolicy(value); policy {
+	case gtgSelfOnly, gtgCriticalGroups, gtgAllGroups:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Invalid GTG policy %q, it must be one of %s, %s or %s", value, gtgSelfOnly, gtgCriticalGroups, gtgAllGroups)
+}
+
+// missingCriticalGroups returns the critical check rules matching none of the consumer groups of any cluster, once per
+// consumer group pattern, as a critical consumer group usually runs on one of the clusters only. Rules without consumer
+// group are left out, as they could match any, and none is missing while the consumer groups of a cluster are unknown.
+func missingCriticalGroups(rules *checkRules, clusters []clusterLag) []checkRule {
+	if rules == nil {
+		return nil
+	}
+	for _, cluster := range clusters {
+		if cluster.err != nil {
+			return nil
+		}
+	}
+	var missing []checkRule
+	seen := map[string]bool{}
+	for _, rule := range rules.rules {
+		if !rule.Critical || rule.ConsumerGroup == nil || seen[rule.ConsumerGroup.String()] {
+			continue
+		}
+		seen[rule.ConsumerGroup.String()] = true
+		if !listsConsumerGroup(clusters, rule.ConsumerGroup) {
+			missing = append(missing, rule)
+		}
+	}
+	return missing
+}
+
+func listsConsumerGroup(clusters []clusterLag, consumerGroup *pattern) bool {
+	for _, cluster := range clusters {
+		for _, lag := range cluster.consumerGroups {
+			if consumerGroup.match(lag.consumerGroup) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// includes tells whether the check of the consumer group counts for the GTG.
+func (h *healthcheck) includes(policy gtgPolicy, lag consumerGroupLag, rules *checkRules) bool {
+	switch policy {
+	case gtgSelfOnly:
+		return false
+	case gtgCriticalGroups:
+		return rules.forConsumerGroup(lag.consumerGroup, lag.topic).critical
+	default:
+		return true
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestParseGTGPolicy(t *testing.T) {
+	for _, value := range []string{"self", "critical", "all"} {
+		policy, err := parseGTGPolicy(value)
+		assert.NoError(t, err)
+		assert.Equal(t, gtgPolicy(value), policy)
+	}
+	_, err := parseGTGPolicy("some")
+	assert.EqualError(t, err, `Invalid GTG policy "some", it must be one of self, critical or all`)
+}
+
+func TestGTGPolicies(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	c, err := parseConfig([]byte(`
+checks:
+  - consumerGroup: content-*
+    critical: true
+`))
+	assert.NoError(t, err)
+
+	consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"content-notifications", "image-publish"},
+	})
+	statusResponse := func(totalLag int) httpmock.Responder {
+		r, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+			"error":   false,
+			"message": "consumer group status returned",
+			"status":  map[string]interface{}{"status": "OK", "partitions": []map[string]interface{}{}, "totallag": totalLag},
+		})
+		return r
+	}
+
+	var testCases = []struct {
+		description    string
+		consumerList   int
+		contentLag     int
+		imageLag       int
+		self           bool
+		criticalGroups bool
+		allGroups      bool
+	}{
+		{description: "all healthy", consumerList: 200, contentLag: 0, imageLag: 0, self: true, criticalGroups: true, allGroups: true},
+		{description: "other group lagging", consumerList: 200, contentLag: 0, imageLag: 100, self: true, criticalGroups: true, allGroups: false},
+		{description: "critical group lagging", consumerList: 200, contentLag: 100, imageLag: 0, self: true, criticalGroups: false, allGroups: false},
+		{description: "Burrow unavailable", consumerList: 500, self: false, criticalGroups: false, allGroups: false},
+	}
+
+	for _, tc := range testCases {
+		if tc.consumerList == 200 {
+			httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+		} else {
+			httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", httpmock.NewStringResponder(tc.consumerList, ""))
+		}
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/content-notifications/lag", statusResponse(tc.contentLag))
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/image-publish/lag", statusResponse(tc.imageLag))
+
+		checks := 2
+		if tc.consumerList != 200 {
+			checks = 1
+		}
+		expected := map[gtgPolicy]bool{gtgSelfOnly: tc.self, gtgCriticalGroups: tc.criticalGroups, gtgAllGroups: tc.allGroups}
+		for policy, goodToGo := range expected {
+			h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), checks: newCheckRules(c.Checks), gtgPolicy: policy})
+			assert.False(t, h.GTG().GoodToGo, "%s with %s policy before the first poll", tc.description, policy)
+			h.poller.poll()
+			assert.Equal(t, goodToGo, h.GTG().GoodToGo, "%s with %s policy", tc.description, policy)
+			assert.Len(t, h.checks(), checks, "the healthcheck reports every group whatever the policy")
+		}
+	}
+}
+
+func TestGTGMissingCriticalGroup(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	c, err := parseConfig([]byte(`
+checks:
+  - consumerGroup: content-notifications
+    critical: true
+    team: content
+  - consumerGroup: image-*
+    critical: true
+`))
+	assert.NoError(t, err)
+	consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"image-publish"},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+	statusResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":   false,
+		"message": "consumer group status returned",
+		"status":  map[string]interface{}{"status": "OK", "partitions": []map[string]interface{}{}, "totallag": 0},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/image-publish/lag", statusResponse)
+
+	expected := map[gtgPolicy]bool{gtgSelfOnly: true, gtgCriticalGroups: false, gtgAllGroups: false}
+	for policy, goodToGo := range expected {
+		h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), checks: newCheckRules(c.Checks), gtgPolicy: policy})
+		h.poller.poll()
+		status := h.GTG()
+		assert.Equal(t, goodToGo, status.GoodToGo, "%s policy", policy)
+		if !goodToGo {
+			assert.Equal(t, "No consumer group matching content-notifications is listed on any of the clusters local", status.Message, "%s policy", policy)
+		}
+
+		checks := h.checks()
+		if assert.Len(t, checks, 2, "%s policy", policy) {
+			assert.Equal(t, "kafka-lagcheck-critical-consumer-group-content-notifications", checks[1].ID)
+			assert.True(t, strings.HasSuffix(checks[1].TechnicalSummary, "Owned by content."), checks[1].TechnicalSummary)
+			_, err := checks[1].Checker()
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestGTGMissingCriticalGroupOnSeveralClusters(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	c, err := parseConfig([]byte(`
+checks:
+  - consumerGroup: content-notifications
+    critical: true
+  - consumerGroup: image-*
+    critical: true
+`))
+	assert.NoError(t, err)
+	for cluster, consumerGroup := range map[string]string{"local": "content-notifications", "remote": "annotations-publish"} {
+		consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+			"error":     false,
+			"message":   "consumer list returned",
+			"consumers": []string{consumerGroup},
+		})
+		statusResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+			"error":   false,
+			"message": "consumer group status returned",
+			"status":  map[string]interface{}{"status": "OK", "partitions": []map[string]interface{}{}, "totallag": 0},
+		})
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/"+cluster+"/consumer", consumersResponse)
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/"+cluster+"/consumer/"+consumerGroup+"/lag", statusResponse)
+	}
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local", "remote"}, thresholds: newThresholds(10, 5, 0, nil), checks: newCheckRules(c.Checks), gtgPolicy: gtgCriticalGroups})
+	h.poller.poll()
+	status := h.GTG()
+	assert.False(t, status.GoodToGo)
+	assert.Equal(t, "No consumer group matching image-* is listed on any of the clusters local, remote", status.Message)
+
+	var missing []string
+	for _, check := range h.checks() {
+		if strings.HasPrefix(check.ID, "kafka-lagcheck-critical-consumer-group-") {
+			missing = append(missing, check.ID)
+		}
+	}
+	assert.Equal(t, []string{"kafka-lagcheck-critical-consumer-group-image---7fa5688d"}, missing)
+}
@@ -1,318 +1,750 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"hash/fnv"
+	"html/template"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
 	"github.com/Financial-Times/service-status-go/gtg"
-	"github.com/jmoiron/jsonq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	systemCode = "kafka-lagcheck"
+	systemCode          = "kafka-lagcheck"
+	defaultPollInterval = 30 * time.Second
 )
 
 type healthcheck struct {
-	whitelistedTopics []string
-	whitelistedEnvs   []string
-	checkPrefix       string
-	maxLagTolerance   int
-	errLagTolerance   int
+	settings   atomic.Value
+	clusters   []string
+	pool       *workerPool
+	retries    *backoff
+	breaker    *circuitBreaker
+	hysteresis *hysteresis
+	silences   *silences
+	gtgPolicy  gtgPolicy
+	history    *lagHistory
+	poller     *poller
+	metrics    *metrics
 }
 
-func newHealthcheck(burrowUrl string, whitelistedTopics []string, whitelistedEnvs []string, maxLagTolerance int, errLagTolerance int) *healthcheck {
-	return &healthcheck{
-		checkPrefix:       burrowUrl + "/v3/kafka/local/consumer/",
-		whitelistedTopics: whitelistedTopics,
-		whitelistedEnvs:   whitelistedEnvs,
-		maxLagTolerance:   maxLagTolerance,
-		errLagTolerance:   errLagTolerance,
-	}
+// healthcheckOptions configure a healthcheck. The lag source and the thresholds are required, the zero value of every
+// other option is a working default: the consumer groups of all the clusters are checked on all their topics, requests
+// to Burrow are neither bounded nor retried nor broken, every evaluation is reported as it is, nothing is silenced,
+// every consumer group counts for the GTG, the lags are polled every 30 seconds, snapshots never go stale, the trends
+// aren't tracked and draining lags have the same tolerances as the others.
+type healthcheckOptions struct {
+	source         lagSource
+	clusters       []string
+	filters        *filters
+	thresholds     *thresholds
+	checks         *checkRules
+	pool           *workerPool
+	retries        *backoff
+	breaker        *circuitBreaker
+	hysteresis     *hysteresis
+	silences       *silences
+	gtgPolicy      gtgPolicy
+	pollInterval   time.Duration
+	maxSnapshotAge time.Duration
+	trendWindow    time.Duration
+	maxDrainTime   time.Duration
+}
+
+func newHealthcheck(options healthcheckOptions) *healthcheck {
+	if options.filters == nil {
+		options.filters = &filters{}
+	}
+	if options.hysteresis == nil {
+		options.hysteresis = newHysteresis(1, 0, 1)
+	}
+	if options.gtgPolicy == "" {
+		options.gtgPolicy = gtgAllGroups
+	}
+	if options.pollInterval <= 0 {
+		options.pollInterval = defaultPollInterval
+	}
+	h := &healthcheck{
+		clusters:   options.clusters,
+		pool:       options.pool,
+		retries:    options.retries,
+		breaker:    options.breaker,
+		hysteresis: options.hysteresis,
+		silences:   options.silences,
+		gtgPolicy:  options.gtgPolicy,
+		history:    newLagHistory(options.trendWindow, options.maxDrainTime),
+	}
+	h.update(&lagSettings{source: options.source, filters: options.filters, thresholds: options.thresholds, checks: options.checks})
+	h.poller = newPoller(h.fetchSnapshot, options.pollInterval, options.maxSnapshotAge)
+	h.metrics = newMetrics(h.poller, options.breaker, h.checkPasses)
+	return h
+}
+
+// lagSettings are the settings of the healthcheck that can be reloaded from the config file while it is running.
+type lagSettings struct {
+	source     lagSource
+	filters    *filters
+	thresholds *thresholds
+	checks     *checkRules
+	webhooks   []*webhook
+}
+
+// current returns the settings in use. A poll loads them once and passes them down, so that the consumer groups of a
+// snapshot are all listed, filtered and checked with the same settings even if the config file is reloaded meanwhile.
+// The checks served are described with the check rules current when they are served.
+func (h *healthcheck) current() *lagSettings {
+	return h.settings.Load().(*lagSettings)
+}
+
+// update swaps the settings in use at once.
+func (h *healthcheck) update(settings *lagSettings) {
+	h.settings.Store(settings)
 }
 
+// Health serves the checks of the latest snapshot. Their lastUpdated is when the lags were fetched from Burrow rather
+// than when the endpoint was called, so that a check can be tracked over time by its ID.
 func (h *healthcheck) Health() func(w http.ResponseWriter, r *http.Request) {
-	consumerGroups, err := h.fetchAndParseConsumerGroups()
-	if err != nil {
-		warnLogger.Println(err.Error())
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer().Start(r.Context(), "Evaluate health")
+		defer span.End()
+		snapshot := h.poller.current()
+		checks := h.checksOf(snapshot, gtgAllGroups)
+		span.SetAttributes(attribute.Int("lagcheck.checks", len(checks)))
 		c := fthealth.TimedHealthCheck{
 			HealthCheck: fthealth.HealthCheck{
 				SystemCode:  systemCode,
 				Name:        "Kafka consumer groups",
 				Description: "Verifies all the defined consumer groups if they have lags.",
-				Checks:      []fthealth.Check{h.burrowUnavailableCheck(err)},
+				Checks:      traceChecks(ctx, checks),
 			},
 			Timeout: 10 * time.Second,
 		}
-		return fthealth.Handler(c)
+		result := fthealth.RunCheck(c)
+		if snapshot != nil {
+			for i := range result.Checks {
+				result.Checks[i].LastUpdated = snapshot.fetchedAt.UTC()
+			}
+		}
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			writeHealthHTML(w, result)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
 	}
+}
 
-	var consumerGroupChecks []fthealth.Check
-	for _, consumer := range consumerGroups {
-		consumerGroupChecks = append(consumerGroupChecks, h.consumerLags(consumer))
+// healthTemplate renders the health result as fthealth.Handler does, which can't be given the result to render, with
+// the times formatted like in the JSON.
+var healthTemplate = template.Must(template.New("health").Parse(`<!DOCTYPE html>
+<head>
+    <title>{{ .Name }} healthchecks</title>
+</head>
+<body>
+    <h4>
+    System code: {{ .SystemCode }}<br>
+    Name: {{ .Name }}<br>
+    Description: {{ .Description }}
+    </h4>
+    <h4>Checks:</h4>
+    <ul>
+        {{ range .Checks }}
+        <li>
+            <strong>{{ .Name }}</strong>
+            <ul>
+                <li><strong>Ok: {{ .Ok }}</strong></li>
+                <li>Severity: {{ .Severity }}</li>
+                <li>Business impact: {{ .BusinessImpact }}</li>
+                <li>Technical summary: {{ .TechnicalSummary }}</li>
+                {{ if .PanicGuideIsLink }}<li>Panic guide: <a href="{{ .PanicGuide }}">{{ .PanicGuide }}</a></li>
+                {{ else }}<li>Panic guide: <pre>{{ .PanicGuide }}</pre></li>{{ end }}
+                <li>Output: {{ .CheckOutput }}</li>
+                <li>Last updated: {{ .LastUpdated.Format "2006-01-02T15:04:05.999999999Z07:00" }}</li>
+            </ul>
+        </li>
+        {{ end }}
+    </ul>
+</body>
+`))
+
+func writeHealthHTML(w http.ResponseWriter, result fthealth.HealthResult) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := healthTemplate.Execute(w, result); err != nil {
+		logger.Warn("Could not write response", "error", err)
 	}
-	if len(consumerGroups) == 0 {
-		c := fthealth.TimedHealthCheck{
-			HealthCheck: fthealth.HealthCheck{
-				SystemCode:  systemCode,
-				Name:        "Kafka consumer groups",
-				Description: "Verifies all the defined consumer groups if they have lags.",
-				Checks:      []fthealth.Check{h.noConsumerGroupsCheck()},
-			},
-			Timeout: 10 * time.Second,
+}
+
+// GTG fails on the first failing check among those of the GTG policy.
+func (h *healthcheck) GTG() gtg.Status {
+	for _, check := range h.checksOf(h.poller.current(), h.gtgPolicy) {
+		if _, err := check.Checker(); err != nil {
+			return gtg.Status{GoodToGo: false, Message: err.Error()}
 		}
-		return fthealth.Handler(c)
 	}
+	return gtg.Status{GoodToGo: true}
+}
 
-	c := fthealth.TimedHealthCheck{
-		HealthCheck: fthealth.HealthCheck{
-			SystemCode:  systemCode,
-			Name:        "Kafka consumer groups",
-			Description: "Verifies all the defined consumer groups if they have lags.",
-			Checks:      consumerGroupChecks,
-		},
-		Timeout: 10 * time.Second,
+// checks builds one check for each consumer group of each cluster found in the latest snapshot taken by the poller,
+// so clusters and groups appearing or disappearing are picked up on the next poll.
+func (h *healthcheck) checks() []fthealth.Check {
+	return h.checksOf(h.poller.current(), gtgAllGroups)
+}
+
+// checksOf builds the checks of the snapshot, leaving out the consumer groups that don't count for the policy.
+func (h *healthcheck) checksOf(snapshot *lagSnapshot, policy gtgPolicy) []fthealth.Check {
+	var checks []fthealth.Check
+	for _, served := range h.servedChecksOf(snapshot, policy) {
+		checks = append(checks, served.check)
 	}
-	return fthealth.Handler(c)
+	return checks
 }
 
-func (h *healthcheck) GTG() gtg.Status {
-	consumerGroups, err := h.fetchAndParseConsumerGroups()
-	if err != nil {
-		warnLogger.Println(err.Error())
-		f := func() gtg.Status {
-			return gtgCheck(func() (string, error) {
-				_, err := h.fetchAndParseConsumerGroups()
-				if err != nil {
-					return "", err
-				}
-				return "", nil
-			})
+// servedChecksOf builds the checks of the snapshot like checksOf, with what they are about.
+func (h *healthcheck) servedChecksOf(snapshot *lagSnapshot, policy gtgPolicy) []servedCheck {
+	if snapshot == nil {
+		return []servedCheck{{check: h.noSnapshotCheck(), kind: burrowCheck}}
+	}
+	if h.poller.isStale(snapshot) {
+		return []servedCheck{{check: h.staleSnapshotCheck(snapshot), kind: burrowCheck}}
+	}
+	if snapshot.circuit.state == circuitOpen {
+		return []servedCheck{{check: h.circuitOpenCheck(snapshot.circuit), kind: burrowCheck}}
+	}
+	if snapshot.err != nil {
+		return []servedCheck{{check: h.clusterListUnavailableCheck(snapshot.err), kind: burrowCheck}}
+	}
+
+	rules := h.current().checks
+	var checks []servedCheck
+	for _, cluster := range snapshot.clusters {
+		if cluster.err != nil {
+			checks = append(checks, servedCheck{check: h.burrowUnavailableCheck(cluster.cluster, cluster.err), kind: burrowCheck, cluster: cluster.cluster})
+			continue
+		}
+		if len(cluster.consumerGroups) == 0 {
+			checks = append(checks, servedCheck{check: h.noConsumerGroupsCheck(cluster.cluster), kind: burrowCheck, cluster: cluster.cluster})
 		}
-		return gtg.FailFastParallelCheck([]gtg.StatusChecker{f})()
-	}
-
-	gtgs := []gtg.StatusChecker{}
-	for _, consumer := range consumerGroups {
-		consumerCopy := consumer
-		gtgF := func() gtg.Status {
-			return gtgCheck(
-				func() (string, error) {
-					return h.fetchAndCheckConsumerGroupForLags(consumerCopy)
-				},
-			)
+		for _, consumerGroup := range cluster.consumerGroups {
+			if h.includes(policy, consumerGroup, rules) {
+				checks = append(checks, servedCheck{
+					check:         h.consumerLags(consumerGroup, rules),
+					kind:          consumerGroupCheck,
+					cluster:       consumerGroup.cluster,
+					consumerGroup: consumerGroup.consumerGroup,
+					topic:         consumerGroup.topic,
+					team:          rules.forConsumerGroup(consumerGroup.consumerGroup, consumerGroup.topic).team,
+					unknown:       consumerGroup.unknown != nil,
+				})
+			}
 		}
-		gtgs = append(gtgs, gtgF)
 	}
+	if policy != gtgSelfOnly {
+		for _, rule := range missingCriticalGroups(rules, snapshot.clusters) {
+			checks = append(checks, servedCheck{
+				check:         h.missingCriticalGroupCheck(snapshot.clusterNames(), rule),
+				kind:          consumerGroupCheck,
+				consumerGroup: rule.ConsumerGroup.String(),
+				team:          rule.Team,
+			})
+		}
+	}
+	return checks
+}
+
+// fetchSnapshot retrieves the consumer group list of every cluster and checks every group for lags in parallel.
+func (h *healthcheck) fetchSnapshot(ctx context.Context) *lagSnapshot {
+	ctx, span := tracer().Start(ctx, "Poll consumer group lags")
+	snapshot := &lagSnapshot{fetchedAt: time.Now()}
+	settings := h.current()
+	clusters, err := h.fetchClusters(ctx, settings)
+	if err != nil {
+		warnRepeated(repeatKey("cluster-list"), "Could not retrieve the cluster list", errorArgs(err)...)
+		endSpan(span, err)
+		snapshot.err = err
+		snapshot.circuit = h.breaker.status()
+		return snapshot
+	}
+	defer span.End()
+	clearRepeated(repeatKey("cluster-list"))
+
+	snapshot.clusters = make([]clusterLag, len(clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range clusters {
+		wg.Add(1)
+		go func(i int, cluster string) {
+			defer wg.Done()
+			snapshot.clusters[i] = h.fetchClusterLag(ctx, settings, cluster)
+		}(i, cluster)
+	}
+	wg.Wait()
+	h.history.prune(time.Now())
+	snapshot.circuit = h.breaker.status()
+	logger.Debug("Fetched consumer group lags", "clusters", len(clusters), "duration", time.Since(snapshot.fetchedAt))
+	return snapshot
+}
+
+// fetchClusters returns the configured clusters, or all the clusters monitored by Burrow if none were configured.
+func (h *healthcheck) fetchClusters(ctx context.Context, settings *lagSettings) ([]string, error) {
+	if len(h.clusters) > 0 {
+		return h.clusters, nil
+	}
+	var clusters []string
+	err := h.requestBurrow(ctx, "cluster_list", func(ctx context.Context) (err error) {
+		clusters, err = settings.source.Clusters(ctx)
+		return err
+	})
+	return clusters, err
+}
 
-	return gtg.FailFastParallelCheck(gtgs)()
+// requestBurrow makes a request to Burrow, retrying it on transient failures unless the circuit breaker is open, and
+// records the duration and outcome of every attempt. Every attempt goes through the worker pool on its own, so it gets
+// its own deadline and no slot is held while waiting to retry.
+func (h *healthcheck) requestBurrow(ctx context.Context, request string, do func(ctx context.Context) error) error {
+	return h.retries.do(ctx, func() error {
+		return h.pool.do(ctx, func(ctx context.Context) error {
+			if !h.breaker.allow(time.Now()) {
+				return errCircuitOpen
+			}
+			start := time.Now()
+			err := do(ctx)
+			duration := time.Since(start)
+			h.metrics.observeBurrowRequest(request, duration, err)
+			if err != nil {
+				logger.Debug("Request to Burrow failed", append([]interface{}{"request", request, "duration", duration}, errorArgs(err)...)...)
+			} else {
+				logger.Debug("Requested Burrow", "request", request, "duration", duration)
+			}
+			h.breaker.record(err, time.Now())
+			return err
+		})
+	}, func() {
+		h.metrics.observeBurrowRetry(request)
+		trace.SpanFromContext(ctx).AddEvent("Retrying request to Burrow", trace.WithAttributes(attribute.String("lagcheck.request", request)))
+	})
 }
 
-func gtgCheck(handler func() (string, error)) gtg.Status {
-	if _, err := handler(); err != nil {
-		return gtg.Status{GoodToGo: false, Message: err.Error()}
+func (h *healthcheck) fetchClusterLag(ctx context.Context, settings *lagSettings, cluster string) clusterLag {
+	ctx, span := tracer().Start(ctx, "Check cluster", trace.WithAttributes(burrow.ClusterKey.String(cluster)))
+	lag := clusterLag{cluster: cluster}
+	start := time.Now()
+	consumerGroups, err := h.fetchAndParseConsumerGroups(ctx, settings, cluster)
+	if err != nil {
+		warnRepeated(repeatKey("consumer-group-list", cluster), "Could not retrieve the consumer group list", append([]interface{}{"cluster", cluster}, errorArgs(err)...)...)
+		endSpan(span, err)
+		lag.err = err
+		return lag
+	}
+	defer span.End()
+	clearRepeated(repeatKey("consumer-group-list", cluster))
+	defer h.hysteresis.prune(cluster, start)
+
+	lag.consumerGroups = make([]consumerGroupLag, len(consumerGroups))
+	var wg sync.WaitGroup
+	for i, consumer := range consumerGroups {
+		wg.Add(1)
+		go func(i int, consumer string) {
+			defer wg.Done()
+			lag.consumerGroups[i] = h.fetchAndCheckConsumerGroupForLags(ctx, settings, cluster, consumer)
+		}(i, consumer)
+	}
+	wg.Wait()
+	return lag
+}
+
+func (h *healthcheck) consumerLags(lag consumerGroupLag, rules *checkRules) fthealth.Check {
+	consumer := lag.consumerGroup
+	metadata := rules.forConsumerGroup(consumer, lag.topic)
+	severity := lag.severity
+	if metadata.severity != 0 {
+		severity = metadata.severity
 	}
-	return gtg.Status{GoodToGo: true}
+	return fthealth.Check{
+		ID:               checkID("consumer-group-lag", lag.cluster, consumer),
+		BusinessImpact:   metadata.businessImpact,
+		Name:             "Consumer group " + consumer + " on cluster " + lag.cluster + " is lagging.",
+		PanicGuide:       metadata.panicGuide,
+		Severity:         severity,
+		TechnicalSummary: metadata.summary(summaryData{Cluster: lag.cluster, ConsumerGroup: consumer, Topic: lag.topic}),
+		Checker: func() (string, error) {
+			if lag.unknown != nil {
+				return "Lag unknown: " + lag.unknown.Error(), nil
+			}
+			if silence := h.silenceOf(lag); silence != nil {
+				output := fmt.Sprintf("ok (silenced until %s: %s)", silence.EndsAt.Format(time.RFC3339), silence.Reason)
+				if lag.err != nil {
+					output += " " + lag.err.Error()
+				}
+				return output, nil
+			}
+			return lag.output, lag.err
+		},
+	}
+}
+
+// checkPasses tells whether the check served for the consumer group passes, silenced or not. The check of a consumer
+// group whose lag is unknown passes too, but the consumer group isn't known to be ok.
+func (h *healthcheck) checkPasses(lag consumerGroupLag) bool {
+	if lag.unknown != nil {
+		return false
+	}
+	_, err := h.consumerLags(lag, h.current().checks).Checker()
+	return err == nil
+}
+
+// silenceOf returns the active silence matching the consumer group and the topics it lags on that are not whitelisted.
+func (h *healthcheck) silenceOf(lag consumerGroupLag) *silence {
+	var topics []string
+	for _, topic := range lag.breakdown.topics {
+		topics = append(topics, topic.topic)
+	}
+	return h.silences.match(lag.cluster, lag.consumerGroup, topics, time.Now())
 }
 
-func (h *healthcheck) consumerLags(consumer string) fthealth.Check {
+func (h *healthcheck) noSnapshotCheck() fthealth.Check {
 	return fthealth.Check{
+		ID:               checkID("lag-snapshot"),
 		BusinessImpact:   "Will delay publishing on respective pipeline.",
-		Name:             "Consumer group " + consumer + " is lagging.",
+		Name:             "Consumer group lags not yet available.",
 		PanicGuide:       "https://runbooks.in.ft.com/kafka-lagcheck",
 		Severity:         1,
-		TechnicalSummary: "Consumer group " + consumer + " is lagging. Further info at: __burrow/v3/kafka/local/consumer/" + consumer + "/status",
+		TechnicalSummary: "The consumer group lags haven't been fetched from burrow yet. This should happen only on startup, please retry in a few moments, and if this case persists, check if burrow is responding.",
 		Checker: func() (string, error) {
-			return h.fetchAndCheckConsumerGroupForLags(consumer)
+			return "", errors.New("Consumer group lags not yet available.")
 		},
 	}
 }
 
-func (h *healthcheck) burrowUnavailableCheck(err error) fthealth.Check {
+func (h *healthcheck) staleSnapshotCheck(snapshot *lagSnapshot) fthealth.Check {
 	return fthealth.Check{
+		ID:               checkID("lag-snapshot"),
 		BusinessImpact:   "Will delay publishing on respective pipeline.",
-		Name:             "Error retrieving consumer group list.",
+		Name:             "Consumer group lags are out of date.",
 		PanicGuide:       "https://runbooks.in.ft.com/kafka-lagcheck",
 		Severity:         1,
-		TechnicalSummary: fmt.Sprintf("Error retrieving consumer group list. Underlying kafka analysis tool burrow@*.service is unavailable. Please restart it or have a look if kafka itself is running properly. %s", err.Error()),
+		TechnicalSummary: fmt.Sprintf("The consumer group lags were last fetched from burrow at %s, more than %v ago. Burrow is probably hanging, please check if burrow@*.service and kafka itself are running properly.", snapshot.fetchedAt.UTC().Format(time.RFC3339), h.poller.maxAge),
 		Checker: func() (string, error) {
-			return "", errors.New("Error retrieving consumer group list.")
+			return "", fmt.Errorf("Consumer group lags are out of date, last fetched %v ago.", snapshot.age().Round(time.Second))
 		},
 	}
 }
 
-func (h *healthcheck) noConsumerGroupsCheck() fthealth.Check {
+func (h *healthcheck) circuitOpenCheck(circuit breakerStatus) fthealth.Check {
 	return fthealth.Check{
+		ID:               checkID("burrow"),
 		BusinessImpact:   "Will delay publishing on respective pipeline.",
-		Name:             "Error retrieving consumer group list.",
+		Name:             "Burrow is unavailable.",
 		PanicGuide:       "https://runbooks.in.ft.com/kafka-lagcheck",
 		Severity:         1,
-		TechnicalSummary: "Can't see any consumers yet so no lags to report and could successfully connect to kafka. This usually should happen only on startup, please retry in a few moments, and if this case persists, take a more serious look at burrow and kafka.",
+		TechnicalSummary: fmt.Sprintf("The last %d requests to burrow failed, so no more are made until %s. Underlying kafka analysis tool burrow@*.service is unavailable. Please restart it or have a look if kafka itself is running properly.", circuit.failures, circuit.retryAt.UTC().Format(time.RFC3339)),
 		Checker: func() (string, error) {
-			return "", nil
+			return "", fmt.Errorf("Burrow is unavailable, circuit breaker open since %s after %d consecutive failed requests, next attempt at %s.",
+				circuit.openedAt.UTC().Format(time.RFC3339), circuit.failures, circuit.retryAt.UTC().Format(time.RFC3339))
 		},
 	}
 }
 
-func (h *healthcheck) fetchAndCheckConsumerGroupForLags(consumerGroup string) (string, error) {
-	resp, err := http.Get(h.checkPrefix + consumerGroup + "/status")
-	if err != nil {
-		warnLogger.Printf("Could not execute request to burrow: %v", err.Error())
-		return "", err
-	}
-	defer properClose(resp)
-	if resp.StatusCode != http.StatusOK {
-		errMsg := fmt.Sprintf("Burrow returned status %d", resp.StatusCode)
-		return "", errors.New(errMsg)
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	lagErr := h.checkConsumerGroupForLags(body, consumerGroup)
-	if lagErr != nil {
-		warnLogger.Printf("Lagging consumers: [%s]", lagErr.Error())
+func (h *healthcheck) clusterListUnavailableCheck(err error) fthealth.Check {
+	return fthealth.Check{
+		ID:               checkID("cluster-list"),
+		BusinessImpact:   "Will delay publishing on respective pipeline.",
+		Name:             "Error retrieving cluster list.",
+		PanicGuide:       "https://runbooks.in.ft.com/kafka-lagcheck",
+		Severity:         1,
+		TechnicalSummary: fmt.Sprintf("Error retrieving the list of kafka clusters. Underlying kafka analysis tool burrow@*.service is unavailable. Please restart it or have a look if kafka itself is running properly. %s", err.Error()),
+		Checker: func() (string, error) {
+			return "", errors.New("Error retrieving cluster list.")
+		},
 	}
-	return "", lagErr
 }
 
-func (h *healthcheck) checkConsumerGroupForLags(body []byte, consumerGroup string) error {
-	fullStatus := map[string]interface{}{}
-	dec := json.NewDecoder(bytes.NewReader(body))
-	err := dec.Decode(&fullStatus)
-	if err != nil {
-		warnLogger.Printf("Could not decode response body to json: %v %v", string(body), err.Error())
-		return errors.New("Could not decode response body to json.")
+func (h *healthcheck) burrowUnavailableCheck(cluster string, err error) fthealth.Check {
+	return fthealth.Check{
+		ID:               checkID("consumer-group-list", cluster),
+		BusinessImpact:   "Will delay publishing on respective pipeline.",
+		Name:             "Error retrieving consumer group list of cluster " + cluster + ".",
+		PanicGuide:       "https://runbooks.in.ft.com/kafka-lagcheck",
+		Severity:         1,
+		TechnicalSummary: fmt.Sprintf("Error retrieving consumer group list of cluster %s. Underlying kafka analysis tool burrow@*.service is unavailable. Please restart it or have a look if kafka itself is running properly. %s", cluster, err.Error()),
+		Checker: func() (string, error) {
+			return "", fmt.Errorf("Error retrieving consumer group list of cluster %s.", cluster)
+		},
 	}
+}
 
-	jq := jsonq.NewQuery(fullStatus)
-	statusError, err := jq.Bool("error")
-	if err != nil {
-		warnLogger.Printf("Couldn't unmarshall consumer status: %v %v", string(body), err.Error())
-		return errors.New("Couldn't unmarshall consumer status.")
+func (h *healthcheck) noConsumerGroupsCheck(cluster string) fthealth.Check {
+	return fthealth.Check{
+		ID:               checkID("consumer-group-list", cluster),
+		BusinessImpact:   "Will delay publishing on respective pipeline.",
+		Name:             "Error retrieving consumer group list of cluster " + cluster + ".",
+		PanicGuide:       "https://runbooks.in.ft.com/kafka-lagcheck",
+		Severity:         1,
+		TechnicalSummary: "Can't see any consumers of cluster " + cluster + " yet so no lags to report and could successfully connect to kafka. This usually should happen only on startup, please retry in a few moments, and if this case persists, take a more serious look at burrow and kafka.",
+		Checker: func() (string, error) {
+			return "", nil
+		},
 	}
+}
 
-	if statusError {
-		warnLogger.Printf("Consumer status response is an error: %v", string(body))
-		return errors.New("Consumer status response is an error.")
+// missingCriticalGroupCheck fails as no consumer group of the clusters matches the critical check rule, which can't be
+// told apart from the consumer group having stopped for long enough for Burrow to forget it.
+func (h *healthcheck) missingCriticalGroupCheck(clusters string, rule checkRule) fthealth.Check {
+	consumerGroup := rule.ConsumerGroup.String()
+	severity, businessImpact, panicGuide := uint8(1), defaultBusinessImpact, defaultPanicGuide
+	if rule.Severity != 0 {
+		severity = rule.Severity
 	}
-
-	status, err := jq.String("status", "status")
-	if err != nil {
-		warnLogger.Printf("Couldn't unmarshall status>status: %v %v", string(body), err.Error())
-		return errors.New("Couldn't unmarshall status > status")
+	if rule.BusinessImpact != "" {
+		businessImpact = rule.BusinessImpact
 	}
-
-	totalLag, err := jq.Int("status", "totallag")
-	if err != nil {
-		warnLogger.Printf("Couldn't unmarshall totallag: %v %v", string(body), err.Error())
-		return errors.New("Couldn't unmarshall totallag.")
+	if rule.PanicGuide != "" {
+		panicGuide = rule.PanicGuide
 	}
-
-	if totalLag > h.maxLagTolerance {
-		return h.ignoreWhitelistedTopics(jq, body, status, totalLag, consumerGroup)
+	summary := "No consumer group matching " + consumerGroup + " is listed by Burrow on any of the clusters " + clusters + ", although it is critical. It may have stopped long enough ago for Burrow to forget it, or have been renamed."
+	if rule.Team != "" {
+		summary += " Owned by " + rule.Team + "."
 	}
-
-	if status != "OK" && totalLag > h.errLagTolerance { // this prevents old / unused consumer groups from causing lags
-		return h.ignoreWhitelistedTopics(jq, body, status, totalLag, consumerGroup)
+	return fthealth.Check{
+		ID:               checkID("critical-consumer-group", consumerGroup),
+		BusinessImpact:   businessImpact,
+		Name:             "Critical consumer group " + consumerGroup + " is missing.",
+		PanicGuide:       panicGuide,
+		Severity:         severity,
+		TechnicalSummary: summary,
+		Checker: func() (string, error) {
+			return "", fmt.Errorf("No consumer group matching %s is listed on any of the clusters %s", consumerGroup, clusters)
+		},
 	}
-
-	return nil
 }
 
-func (h *healthcheck) ignoreWhitelistedTopics(jq *jsonq.JsonQuery, body []byte, status string, lag int, consumerGroup string) error {
-	topic1, err1 := jq.String("status", "maxlag", "topic")
-	topic2, err2 := jq.String("status", "partitions", "0", "topic")
-	if err1 != nil && err2 != nil {
-		warnLogger.Printf("Couldn't unmarshall topic: %v %v %v", string(body), err1.Error(), err2.Error())
-		return errors.New("Couldn't unmarshall topic.")
-	}
-	topic := topic1
-	if topic == "" {
-		topic = topic2
-	}
-	for _, whitelistedTopic := range h.whitelistedTopics {
-		if topic == whitelistedTopic {
-			return nil
+// checkID joins the given parts into a check ID made of lowercase letters, digits and dashes only. The other characters
+// are replaced, and as that could make different names share an ID (e.g. content.notifications and
+// content-notifications), a part with any of them is followed by a short hash of the part as given.
+func checkID(parts ...string) string {
+	id := systemCode
+	for _, part := range parts {
+		lossy := false
+		id += "-" + strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+				return r
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				lossy = true
+				return unicode.ToLower(r)
+			default:
+				lossy = true
+				return '-'
+			}
+		}, part)
+		if lossy {
+			hash := fnv.New32a()
+			hash.Write([]byte(part))
+			id += fmt.Sprintf("-%08x", hash.Sum32())
 		}
 	}
-	return fmt.Errorf("%s consumer group is lagging behind with %d messages. Status of the consumer group is %s", consumerGroup, lag, status)
+	return id
 }
 
-func (h *healthcheck) fetchAndParseConsumerGroups() ([]string, error) {
-	resp, err := http.Get(h.checkPrefix)
-	if err != nil {
-		warnLogger.Printf("Could not execute request to burrow: %v", err.Error())
-		return nil, err
-	}
-	defer properClose(resp)
-	if resp.StatusCode != http.StatusOK {
-		errMsg := fmt.Sprintf("Burrow returned status %d", resp.StatusCode)
-		return nil, errors.New(errMsg)
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	return h.parseConsumerGroups(body)
+// fetchAndCheckConsumerGroupForLags checks the consumer group and smooths the outcome with the hysteresis.
+// The streaks of a consumer group whose lag is unknown are left as they are.
+// The evaluation is traced, with the lag when known, and whether the consumer group fails once smoothed.
+func (h *healthcheck) fetchAndCheckConsumerGroupForLags(ctx context.Context, settings *lagSettings, cluster string, consumerGroup string) consumerGroupLag {
+	ctx, span := tracer().Start(ctx, "Evaluate consumer group", trace.WithAttributes(burrow.ClusterKey.String(cluster), burrow.ConsumerGroupKey.String(consumerGroup)))
+	defer span.End()
+	lag := h.fetchAndCheckConsumerGroup(ctx, settings, cluster, consumerGroup)
+	if lag.unknown != nil {
+		h.hysteresis.keep(cluster, consumerGroup, time.Now())
+		span.SetAttributes(unknownKey.Bool(true))
+		span.SetStatus(codes.Error, lag.unknown.Error())
+		return lag
+	}
+	lag.flap = h.hysteresis.evaluate(cluster, consumerGroup, lag.err, time.Now())
+	lag.err = lag.flap.err
+	if lag.flap.output != "" {
+		lag.output = lag.flap.output
+	}
+	span.SetAttributes(failingKey.Bool(lag.err != nil))
+	return lag
 }
 
-func properClose(resp *http.Response) {
-	io.Copy(ioutil.Discard, resp.Body)
-	err := resp.Body.Close()
-	if err != nil {
-		warnLogger.Printf("Could not close response body: %v", err.Error())
+func (h *healthcheck) fetchAndCheckConsumerGroup(ctx context.Context, settings *lagSettings, cluster string, consumerGroup string) consumerGroupLag {
+	lag := consumerGroupLag{cluster: cluster, consumerGroup: consumerGroup}
+	var status *burrow.ConsumerGroupStatus
+	err := h.requestBurrow(ctx, "consumer_status", func(ctx context.Context) (err error) {
+		status, err = settings.source.ConsumerLag(ctx, cluster, consumerGroup)
+		return err
+	})
+	var unknownLag *unknownLagError
+	if err != nil && errors.As(err, &unknownLag) {
+		warnRepeated(repeatKey("status", cluster, consumerGroup), "Could not compute the whole lag of the consumer group",
+			append([]interface{}{"cluster", cluster, "consumer_group", consumerGroup}, errorArgs(err)...)...)
+		lag.severity = settings.thresholds.forConsumerGroup(consumerGroup, "").severity
+		lag.unknown = err
+		return lag
+	}
+	if err != nil && (isTimeout(err) || errors.Is(err, errCircuitOpen)) {
+		warnRepeated(repeatKey("status", cluster, consumerGroup), "Could not retrieve the status of the consumer group in time",
+			append([]interface{}{"cluster", cluster, "consumer_group", consumerGroup}, errorArgs(err)...)...)
+		lag.severity = settings.thresholds.forConsumerGroup(consumerGroup, "").severity
+		lag.unknown = err
+		return lag
 	}
+	if err != nil {
+		warnRepeated(repeatKey("status", cluster, consumerGroup), "Could not retrieve the status of the consumer group",
+			append([]interface{}{"cluster", cluster, "consumer_group", consumerGroup}, errorArgs(err)...)...)
+		lag.severity = settings.thresholds.forConsumerGroup(consumerGroup, "").severity
+		lag.err = err
+		return lag
+	}
+	breakdown := breakdownLag(settings.filters, status)
+	lag.status = status
+	lag.breakdown = breakdown
+	lag.trends = h.history.record(cluster, consumerGroup, breakdown, time.Now())
+	var breached string
+	breached, lag.err = checkConsumerGroupForLags(settings, status, consumerGroup, lag.trends)
+	// the check of a lagging consumer group is about the topic it lags on beyond its tolerance, otherwise the one it
+	// lags the most on
+	lag.topic = breakdown.topic()
+	if lag.err != nil {
+		lag.topic = breached
+	}
+	lag.severity = settings.thresholds.forConsumerGroup(consumerGroup, lag.topic).severity
+	trace.SpanFromContext(ctx).SetAttributes(burrow.TopicKey.String(lag.topic), lagKey.Int64(breakdown.lag))
+	clearRepeated(repeatKey("status", cluster, consumerGroup))
+	if lag.err != nil {
+		warnRepeated(repeatKey("lagging", cluster, consumerGroup), "Consumer group is lagging",
+			"cluster", cluster, "consumer_group", consumerGroup, "topic", lag.topic, "lag", breakdown.lag, "error", lag.err)
+	} else {
+		clearRepeated(repeatKey("lagging", cluster, consumerGroup))
+		lag.output = "Lag of " + describeLag(breakdown, lag.trends.group)
+	}
+	return lag
 }
 
-func (h *healthcheck) parseConsumerGroups(body []byte) ([]string, error) {
-	fullConsumers := make(map[string]interface{})
-	err := json.NewDecoder(bytes.NewReader(body)).Decode(&fullConsumers)
-	if err != nil {
-		warnLogger.Printf("Could not decode response body to json: %v %v", string(body), err.Error())
-		return nil, fmt.Errorf("Could not decode response body to json: %v %v", string(body), err)
+// checkConsumerGroupForLags checks the lag of the consumer group on the topics that are not whitelisted, and returns the
+// topic it lags on beyond its tolerance along with the failure. The lag on a topic matching a threshold rule with a
+// topic is compared with the tolerances of that rule, and the lag on the other topics, summed, with the tolerances of
+// the consumer group, the failure then being about the one it lags the most on. The tolerances on the number of
+// messages don't apply to a lag catching up: the lag on a topic with its own rule if that topic catches up, and the
+// lag on the other topics if the consumer group catches up while none of them grows.
+func checkConsumerGroupForLags(settings *lagSettings, status *burrow.ConsumerGroupStatus, consumerGroup string, trends lagTrends) (string, error) {
+	breakdown := breakdownLag(settings.filters, status)
+	rest := groupLagBreakdown{lag: breakdown.lag, status: breakdown.status, whitelisted: breakdown.whitelisted}
+	restCatchingUp := trends.group.catchingUp
+	for _, topic := range breakdown.topics {
+		threshold := settings.thresholds.forConsumerGroup(consumerGroup, topic.topic)
+		trend := trends.topics[topic.topic]
+		if !threshold.byTopic {
+			rest.topics = append(rest.topics, topic)
+			if trend.direction == trendGrowing {
+				restCatchingUp = false
+			}
+			continue
+		}
+		rest.lag -= topic.lag
+		onTopic := groupLagBreakdown{lag: topic.lag, status: breakdown.statusOn(topic), topics: []topicLag{topic}}
+		if !trend.catchingUp && threshold.exceededBy(onTopic) {
+			return topic.topic, laggingError(consumerGroup, onTopic, trend)
+		}
 	}
-	jq := jsonq.NewQuery(fullConsumers)
-	statusError, err := jq.Bool("error")
-	if err != nil {
-		return nil, fmt.Errorf("Couldn't unmarshall consumer list response: %v %v", string(body), err)
+	if rest.lag < 0 {
+		rest.lag = 0
 	}
-	if statusError {
-		return nil, fmt.Errorf("Consumer list response is an error: %v", string(body))
+	if !restCatchingUp && settings.thresholds.forConsumerGroup(consumerGroup, "").exceededBy(rest) {
+		return rest.topic(), laggingError(consumerGroup, rest, trends.group)
 	}
-	consumers, err := jq.ArrayOfStrings("consumers")
-	if err != nil {
-		warnLogger.Printf("Couldn't unmarshall consumer list: %s %s", string(body), err.Error())
-		return nil, fmt.Errorf("Couldn't unmarshall consumer list: %s %s", string(body), err)
+
+	return checkPartitionsForTimeLag(settings, status, consumerGroup)
+}
+
+// exceededBy tells whether the lag is beyond the message tolerances.
+func (t threshold) exceededBy(lag groupLagBreakdown) bool {
+	if t.maxLagTolerance >= 0 && lag.lag > int64(t.maxLagTolerance) {
+		return true
 	}
-	return h.filterOutNonRelatedKafkaBridges(consumers), nil
+	// this prevents old / unused consumer groups from causing lags
+	return t.errLagTolerance >= 0 && lag.status != burrow.StatusOK && lag.lag > int64(t.errLagTolerance)
 }
 
-func (h *healthcheck) filterOutNonRelatedKafkaBridges(consumers []string) []string {
-	filteredConsumers := []string{}
-	for _, consumer := range consumers {
-		if strings.Contains(consumer, "kafka-bridge") && !h.isBridgeFromWhitelistedEnvs(consumer) {
+// checkPartitionsForTimeLag fails, with the topic of the partition, if the consumer group is further behind in time
+// than the threshold of the topic tolerates on any of the partitions Burrow reports, ignoring the whitelisted topics.
+func checkPartitionsForTimeLag(settings *lagSettings, status *burrow.ConsumerGroupStatus, consumerGroup string) (string, error) {
+	partitions := status.Partitions
+	if status.MaxLag != nil {
+		partitions = append([]burrow.PartitionStatus{*status.MaxLag}, partitions...)
+	}
+
+	now := time.Now()
+	var worst *burrow.PartitionStatus
+	var worstTimeLag, worstMaxTimeLag time.Duration
+	for i, partition := range partitions {
+		if !settings.filters.checksTopic(partition.Topic) {
 			continue
 		}
-
-		filteredConsumers = append(filteredConsumers, consumer)
+		maxTimeLag := settings.thresholds.forConsumerGroup(consumerGroup, partition.Topic).maxTimeLag
+		if maxTimeLag <= 0 {
+			continue
+		}
+		if timeLag := partition.TimeLag(now); timeLag > maxTimeLag && timeLag > worstTimeLag {
+			worst = &partitions[i]
+			worstTimeLag, worstMaxTimeLag = timeLag, maxTimeLag
+		}
+	}
+	if worst == nil {
+		return "", nil
 	}
+	return worst.Topic, fmt.Errorf("%s consumer group is %v behind on partition %d of topic %s, more than the tolerated %v. Status of the consumer group is %s",
+		consumerGroup, worstTimeLag.Round(time.Second), worst.Partition, worst.Topic, worstMaxTimeLag, status.Status)
+}
 
-	return filteredConsumers
+func laggingError(consumerGroup string, breakdown groupLagBreakdown, trend lagTrend) error {
+	return errors.New(consumerGroup + " consumer group is lagging behind with " + describeLag(breakdown, trend))
 }
 
-func (h *healthcheck) isBridgeFromWhitelistedEnvs(bridgeName string) bool {
-	//Do not filter out any Kafka bridge by default
-	if len(h.whitelistedEnvs) == 0 {
-		return true
+// describeLag describes the lag of a consumer group, the partitions lagging the most and its trend.
+func describeLag(breakdown groupLagBreakdown, trend lagTrend) string {
+	msg := fmt.Sprintf("%d messages", breakdown.lag)
+	if topics := describeTopics(breakdown.topics); topics != "" {
+		msg += " on " + topics
 	}
+	if whitelisted := describeTopics(breakdown.whitelisted); whitelisted != "" {
+		msg += ", ignoring whitelisted " + whitelisted
+	}
+	msg = fmt.Sprintf("%s. Status of the consumer group is %s", msg, breakdown.status)
+	if trend.direction != trendUnknown {
+		msg += ". Lag is " + trend.String()
+	}
+	return msg
+}
 
-	for _, whitelistedEnv := range h.whitelistedEnvs {
-		if strings.HasPrefix(bridgeName, whitelistedEnv) {
-			return true
-		}
+func (h *healthcheck) fetchAndParseConsumerGroups(ctx context.Context, settings *lagSettings, cluster string) ([]string, error) {
+	var consumers []string
+	err := h.requestBurrow(ctx, "consumer_list", func(ctx context.Context) (err error) {
+		consumers, err = settings.source.Consumers(ctx, cluster)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return filterConsumerGroups(settings.filters, consumers), nil
+}
 
-	return false
+func filterConsumerGroups(filters *filters, consumers []string) []string {
+	filteredConsumers := []string{}
+	for _, consumer := range consumers {
+		if filters.checksConsumerGroup(consumer) {
+			filteredConsumers = append(filteredConsumers, consumer)
+		}
+	}
+	return filteredConsumers
 }
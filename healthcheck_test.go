@@ -2,17 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"testing"
 
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/jarcoal/httpmock.v1"
@@ -23,16 +29,6 @@ func TestConsumerStatus(t *testing.T) {
 		body []byte
 		err  error
 	}{
-		{
-			body: []byte(`{}`),
-			err:  errors.New("Couldn't unmarshall consumer status."),
-		},
-		{
-			body: []byte(`{
-				"error": true
-			}`),
-			err: errors.New("Consumer status response is an error."),
-		},
 		{
 			// Lag is not 0 but below threshold.
 			body: []byte(`{
@@ -97,7 +93,7 @@ func TestConsumerStatus(t *testing.T) {
 				}
 			}
 			`),
-			err: errors.New("xp-notifications-push-2 consumer group is lagging behind with 31 messages. Status of the consumer group is OK"),
+			err: errors.New("xp-notifications-push-2 consumer group is lagging behind with 31 messages on CmsPublicationEvents (partition 0: 31). Status of the consumer group is OK"),
 		},
 		{
 			/*
@@ -133,7 +129,7 @@ func TestConsumerStatus(t *testing.T) {
 				}
 			}
 			`),
-			err: errors.New("xp-notifications-push-2 consumer group is lagging behind with 9 messages. Status of the consumer group is WARNING"),
+			err: errors.New("xp-notifications-push-2 consumer group is lagging behind with 9 messages on CmsPublicationEvents (partition 0: 9). Status of the consumer group is WARNING"),
 		},
 		{
 			/*
@@ -263,10 +259,15 @@ func TestConsumerStatus(t *testing.T) {
 			err: nil,
 		},
 	}
-	initLogs(ioutil.Discard, ioutil.Discard, ioutil.Discard)
-	h := newHealthcheck("", []string{"Concept"}, []string{}, 30, 5)
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, filters: newTestFilters([]string{"Concept"}, []string{}), thresholds: newThresholds(30, 5, 0, nil)})
 	for _, tc := range testCases {
-		actualErr := h.checkConsumerGroupForLags(tc.body, "xp-notifications-push-2")
+		var resp struct {
+			Status *burrow.ConsumerGroupStatus `json:"status"`
+		}
+		err := json.Unmarshal(tc.body, &resp)
+		assert.NoError(t, err)
+		_, actualErr := checkConsumerGroupForLags(h.current(), resp.Status, "xp-notifications-push-2", lagTrends{})
 		actualMsg := "<nil>"
 		if actualErr != nil {
 			actualMsg = actualErr.Error()
@@ -281,50 +282,6 @@ func TestConsumerStatus(t *testing.T) {
 	}
 }
 
-func TestUnmarshalBurrowResponseFails(t *testing.T) {
-	testCases := []struct {
-		body  []byte
-		err   string
-		fails bool
-	}{
-		{
-			body:  []byte(``),
-			err:   "Could not decode response body to json.",
-			fails: true,
-		},
-		{
-			body:  []byte(`{"error": null}`),
-			err:   "Couldn't unmarshall consumer status.",
-			fails: true,
-		},
-		{
-			body:  []byte(`{"error":false, "status": {}}`),
-			err:   "Couldn't unmarshall status > status",
-			fails: true,
-		},
-		{
-			body:  []byte(`{"error":false, "status": {"status":1,"totallag": "hi"}}`),
-			err:   "Couldn't unmarshall status > status",
-			fails: true,
-		},
-		{
-			body:  []byte(`{"error":false, "status": {"status":"any string","totallag": "hi"}}`),
-			err:   "Couldn't unmarshall totallag.",
-			fails: true,
-		},
-	}
-
-	h := newHealthcheck("", []string{"Concept"}, []string{}, 30, 10)
-	for _, tc := range testCases {
-		err := h.checkConsumerGroupForLags(tc.body, "xp-notifications-push-2")
-		if tc.fails {
-			assert.EqualError(t, err, tc.err)
-		} else {
-			assert.NoError(t, err)
-		}
-	}
-}
-
 func TestConsumerList(t *testing.T) {
 	var testCases = []struct {
 		body      []byte
@@ -333,14 +290,14 @@ func TestConsumerList(t *testing.T) {
 	}{
 		{
 			body:      []byte("{}"),
-			err:       errors.New("Couldn't unmarshall consumer list response"),
+			err:       errors.New("Consumer list response for cluster local has no consumers"),
 			consumers: nil,
 		},
 		{
 			body: []byte(`{
 				"error": true
 			}`),
-			err:       errors.New("Consumer list response is an error"),
+			err:       errors.New("Burrow returned status 200 for http://burrow.example.com/v3/kafka/local/consumer"),
 			consumers: nil,
 		},
 		{
@@ -348,7 +305,7 @@ func TestConsumerList(t *testing.T) {
 				"error": false,
 				"message": "consumer group status returned"
 			}`),
-			err:       errors.New("Couldn't unmarshall consumer list"),
+			err:       errors.New("Consumer list response for cluster local has no consumers"),
 			consumers: nil,
 		},
 		{
@@ -393,10 +350,15 @@ func TestConsumerList(t *testing.T) {
 			consumers: []string{},
 		},
 	}
-	initLogs(ioutil.Discard, ioutil.Discard, ioutil.Discard)
-	h := newHealthcheck("", []string{"Concept"}, []string{"lower-env1"}, 30, 10)
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, filters: newTestFilters([]string{"Concept"}, []string{"lower-env1"}), thresholds: newThresholds(30, 10, 0, nil)})
 	for _, tc := range testCases {
-		consumers, actualErr := h.parseConsumerGroups(tc.body)
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", httpmock.NewBytesResponder(200, tc.body))
+		consumers, actualErr := h.fetchAndParseConsumerGroups(context.Background(), h.current(), "local")
 		actualMsg := "<nil>"
 		if actualErr != nil {
 			actualMsg = actualErr.Error()
@@ -440,8 +402,8 @@ func TestFilterOutNonRelatedKafkaBridges(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		h := newHealthcheck("", []string{""}, tc.whitelistedEnvs, 30, 10)
-		filteredConsumers := h.filterOutNonRelatedKafkaBridges(tc.consumers)
+		h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, filters: newTestFilters([]string{""}, tc.whitelistedEnvs), thresholds: newThresholds(30, 10, 0, nil)})
+		filteredConsumers := filterConsumerGroups(h.current().filters, tc.consumers)
 		for i, c := range filteredConsumers {
 			if c != tc.expected[i] {
 				t.Errorf("Consumers do not match. Expected: [%s]\nActual: [%s]", tc.expected, filteredConsumers)
@@ -462,7 +424,7 @@ func TestGTG(t *testing.T) {
 		"consumers": []string{"consumer1", "consumer2"},
 	}
 	consumersResponse, _ := httpmock.NewJsonResponder(200, consumers)
-	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/", consumersResponse)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
 
 	consumerStatus := []map[string]interface{}{
 		map[string]interface{}{
@@ -496,19 +458,28 @@ func TestGTG(t *testing.T) {
 	}
 	for i, status := range consumerStatus {
 		statusResponse, _ := httpmock.NewJsonResponder(200, status)
-		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/v3/kafka/local/consumer/consumer%d/status", burrowUrl, i+1), statusResponse)
+		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/v3/kafka/local/consumer/consumer%d/lag", burrowUrl, i+1), statusResponse)
 	}
 
-	h := newHealthcheck(burrowUrl, []string{}, []string{}, 0, 0)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(0, 0, 0, nil)})
 
 	req, _ := http.NewRequest("GET", "http://localhost/__gtg", nil)
 	w := httptest.NewRecorder()
+	h.poller.poll()
 	http.HandlerFunc(status.NewGoodToGoHandler(h.GTG))(w, req)
 
 	actual := *w.Result()
 	assert.Equal(t, actual.StatusCode, http.StatusOK, "GTG HTTP status")
 }
 
+func newTestFilters(whitelistedTopics []string, whitelistedEnvs []string) *filters {
+	f, err := newFilters(whitelistedTopics, whitelistedEnvs, nil, &config{})
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
 type syncWriter struct {
 	sync.Mutex
 	buf bytes.Buffer
@@ -530,7 +501,7 @@ func (w *syncWriter) Bytes() []byte {
 
 func TestGTGLaggingBeyondLimit(t *testing.T) {
 	buf := &syncWriter{}
-	initLogs(buf, buf, buf)
+	initLogs(buf, slog.LevelInfo, time.Minute)
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -543,7 +514,7 @@ func TestGTGLaggingBeyondLimit(t *testing.T) {
 		"consumers": []string{"consumer1", "consumer2"},
 	}
 	consumersResponse, _ := httpmock.NewJsonResponder(200, consumers)
-	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/", consumersResponse)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
 
 	consumerStatus := []map[string]interface{}{
 		map[string]interface{}{
@@ -577,13 +548,14 @@ func TestGTGLaggingBeyondLimit(t *testing.T) {
 	}
 	for i, status := range consumerStatus {
 		statusResponse, _ := httpmock.NewJsonResponder(200, status)
-		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/v3/kafka/local/consumer/consumer%d/status", burrowUrl, i+1), statusResponse)
+		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/v3/kafka/local/consumer/consumer%d/lag", burrowUrl, i+1), statusResponse)
 	}
 
-	h := newHealthcheck(burrowUrl, []string{}, []string{}, 5, 1)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(5, 1, 0, nil)})
 
 	req, _ := http.NewRequest("GET", "http://localhost/__gtg", nil)
 	w := httptest.NewRecorder()
+	h.poller.poll()
 	http.HandlerFunc(status.NewGoodToGoHandler(h.GTG))(w, req)
 	actual := *w.Result()
 	assert.Equal(t, actual.StatusCode, http.StatusServiceUnavailable, "GTG HTTP status")
@@ -591,11 +563,19 @@ func TestGTGLaggingBeyondLimit(t *testing.T) {
 	// give a little time for logging goroutine to catch up
 	time.Sleep(100 * time.Millisecond)
 
-	logs := string(buf.Bytes())
-	for _, status := range consumerStatus {
-		expected := fmt.Sprintf(".*Lagging consumers:.+consumer[\\d] consumer group is lagging behind with %d messages.*", status["status"].(map[string]interface{})["totallag"])
-		assert.Regexp(t, expected, logs, "lagging consumer log")
+	lagging := map[string]interface{}{}
+	for _, line := range logLines(t, buf.Bytes()) {
+		if line["msg"] == "Consumer group is lagging" {
+			assert.Equal(t, "WARN", line["level"])
+			assert.Equal(t, "local", line["cluster"])
+			lagging[line["consumer_group"].(string)] = line["lag"]
+		}
 	}
+	assert.Equal(t, map[string]interface{}{"consumer1": 10.0, "consumer2": 6.0}, lagging, "lagging consumer logs")
+
+	// still lagging on the next poll, within the repeat interval
+	h.poller.poll()
+	assert.Len(t, logLines(t, buf.Bytes()), len(lagging))
 }
 
 func TestGTGLaggingWithinLimit(t *testing.T) {
@@ -610,7 +590,7 @@ func TestGTGLaggingWithinLimit(t *testing.T) {
 		"consumers": []string{"consumer1", "consumer2"},
 	}
 	consumersResponse, _ := httpmock.NewJsonResponder(200, consumers)
-	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/", consumersResponse)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
 
 	consumerStatus := []map[string]interface{}{
 		map[string]interface{}{
@@ -644,15 +624,957 @@ func TestGTGLaggingWithinLimit(t *testing.T) {
 	}
 	for i, status := range consumerStatus {
 		statusResponse, _ := httpmock.NewJsonResponder(200, status)
-		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/v3/kafka/local/consumer/consumer%d/status", burrowUrl, i+1), statusResponse)
+		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/v3/kafka/local/consumer/consumer%d/lag", burrowUrl, i+1), statusResponse)
 	}
 
-	h := newHealthcheck(burrowUrl, []string{}, []string{}, 10, 5)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil)})
 
 	req, _ := http.NewRequest("GET", "http://localhost/__gtg", nil)
 	w := httptest.NewRecorder()
+	h.poller.poll()
 	http.HandlerFunc(status.NewGoodToGoHandler(h.GTG))(w, req)
 
 	actual := *w.Result()
 	assert.Equal(t, actual.StatusCode, http.StatusOK, "GTG HTTP status")
 }
+
+func TestHealthRediscoversConsumerGroups(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+
+	consumerStatus := map[string]interface{}{
+		"error":   false,
+		"message": "consumer group status returned",
+		"status": map[string]interface{}{
+			"status":          "OK",
+			"complete":        true,
+			"partitions":      []map[string]interface{}{},
+			"partition_count": 1,
+			"maxlag":          nil,
+			"totallag":        0,
+		},
+	}
+	for _, consumer := range []string{"consumer1", "consumer2", "consumer3"} {
+		statusResponse, _ := httpmock.NewJsonResponder(200, consumerStatus)
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/"+consumer+"/lag", statusResponse)
+	}
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil)})
+	handler := h.Health()
+
+	var testCases = []struct {
+		description  string
+		consumers    []string
+		burrowStatus int
+		checkNames   []string
+	}{
+		{
+			description:  "Burrow unavailable at startup",
+			burrowStatus: http.StatusServiceUnavailable,
+			checkNames:   []string{"Error retrieving consumer group list of cluster local."},
+		},
+		{
+			description:  "Burrow recovered with groups",
+			consumers:    []string{"consumer1", "consumer2"},
+			burrowStatus: http.StatusOK,
+			checkNames:   []string{"Consumer group consumer1 on cluster local is lagging.", "Consumer group consumer2 on cluster local is lagging."},
+		},
+		{
+			description:  "New group appeared",
+			consumers:    []string{"consumer1", "consumer2", "consumer3"},
+			burrowStatus: http.StatusOK,
+			checkNames:   []string{"Consumer group consumer1 on cluster local is lagging.", "Consumer group consumer2 on cluster local is lagging.", "Consumer group consumer3 on cluster local is lagging."},
+		},
+		{
+			description:  "Groups disappeared",
+			consumers:    []string{"consumer3"},
+			burrowStatus: http.StatusOK,
+			checkNames:   []string{"Consumer group consumer3 on cluster local is lagging."},
+		},
+	}
+
+	for _, tc := range testCases {
+		consumers := map[string]interface{}{
+			"error":     false,
+			"message":   "consumer list returned",
+			"consumers": tc.consumers,
+		}
+		consumersResponse, _ := httpmock.NewJsonResponder(tc.burrowStatus, consumers)
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+
+		h.poller.poll()
+		req, _ := http.NewRequest("GET", "http://localhost/__health", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		var result fthealth.HealthResult
+		err := json.NewDecoder(w.Body).Decode(&result)
+		assert.NoError(t, err, tc.description)
+
+		var actualNames []string
+		for _, check := range result.Checks {
+			actualNames = append(actualNames, check.Name)
+		}
+		assert.Equal(t, tc.checkNames, actualNames, tc.description)
+	}
+}
+
+func TestHealthAndGTGServeFromSnapshot(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+
+	var burrowCalls int
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", func(req *http.Request) (*http.Response, error) {
+		burrowCalls++
+		return httpmock.NewJsonResponse(200, map[string]interface{}{
+			"error":     false,
+			"message":   "consumer list returned",
+			"consumers": []string{},
+		})
+	})
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), maxSnapshotAge: time.Minute})
+
+	var result fthealth.HealthResult
+	w := httptest.NewRecorder()
+	h.Health()(w, httptest.NewRequest("GET", "http://localhost/__health", nil))
+	json.NewDecoder(w.Body).Decode(&result)
+	assert.False(t, result.Ok, "health before first poll")
+	assert.Equal(t, "Consumer group lags not yet available.", result.Checks[0].Name)
+	assert.False(t, h.GTG().GoodToGo, "GTG before first poll")
+
+	h.poller.poll()
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		h.Health()(w, httptest.NewRequest("GET", "http://localhost/__health", nil))
+		assert.True(t, h.GTG().GoodToGo, "GTG after poll")
+	}
+	assert.Equal(t, 1, burrowCalls, "Burrow calls")
+
+	h.poller.snapshot.fetchedAt = time.Now().Add(-2 * time.Minute)
+	w = httptest.NewRecorder()
+	h.Health()(w, httptest.NewRequest("GET", "http://localhost/__health", nil))
+	json.NewDecoder(w.Body).Decode(&result)
+	assert.False(t, result.Ok, "health with stale snapshot")
+	assert.Equal(t, "Consumer group lags are out of date.", result.Checks[0].Name)
+	gtgStatus := h.GTG()
+	assert.False(t, gtgStatus.GoodToGo, "GTG with stale snapshot")
+	assert.Contains(t, gtgStatus.Message, "Consumer group lags are out of date")
+}
+
+func TestHealthChecksEveryCluster(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+
+	clusters := map[string]interface{}{
+		"error":    false,
+		"message":  "cluster list returned",
+		"clusters": []string{"local", "remote", "broken"},
+	}
+	clustersResponse, _ := httpmock.NewJsonResponder(200, clusters)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka", clustersResponse)
+
+	for _, cluster := range []string{"local", "remote"} {
+		consumers := map[string]interface{}{
+			"error":     false,
+			"message":   "consumer list returned",
+			"consumers": []string{"consumer1"},
+		}
+		consumersResponse, _ := httpmock.NewJsonResponder(200, consumers)
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/"+cluster+"/consumer", consumersResponse)
+	}
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/broken/consumer", httpmock.NewStringResponder(500, ""))
+
+	statusResponse := func(totalLag int) httpmock.Responder {
+		r, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+			"error":   false,
+			"message": "consumer group status returned",
+			"status": map[string]interface{}{
+				"status":          "OK",
+				"complete":        1.0,
+				"partitions":      []map[string]interface{}{},
+				"partition_count": 1,
+				"maxlag":          nil,
+				"totallag":        totalLag,
+			},
+		})
+		return r
+	}
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer1/lag", statusResponse(0))
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/remote/consumer/consumer1/lag", statusResponse(100))
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), thresholds: newThresholds(10, 5, 0, nil)})
+	h.poller.poll()
+
+	w := httptest.NewRecorder()
+	h.Health()(w, httptest.NewRequest("GET", "http://localhost/__health", nil))
+	var result fthealth.HealthResult
+	err := json.NewDecoder(w.Body).Decode(&result)
+	assert.NoError(t, err)
+
+	expected := []struct {
+		id      string
+		name    string
+		ok      bool
+		summary string
+	}{
+		{
+			id:      "kafka-lagcheck-consumer-group-lag-local-consumer1",
+			name:    "Consumer group consumer1 on cluster local is lagging.",
+			ok:      true,
+			summary: "__burrow/v3/kafka/local/consumer/consumer1/lag",
+		},
+		{
+			id:      "kafka-lagcheck-consumer-group-lag-remote-consumer1",
+			name:    "Consumer group consumer1 on cluster remote is lagging.",
+			ok:      false,
+			summary: "__burrow/v3/kafka/remote/consumer/consumer1/lag",
+		},
+		{
+			id:      "kafka-lagcheck-consumer-group-list-broken",
+			name:    "Error retrieving consumer group list of cluster broken.",
+			ok:      false,
+			summary: "Error retrieving consumer group list of cluster broken.",
+		},
+	}
+	if assert.Len(t, result.Checks, len(expected)) {
+		for i, check := range result.Checks {
+			assert.Equal(t, expected[i].id, check.ID)
+			assert.Equal(t, expected[i].name, check.Name)
+			assert.Equal(t, expected[i].ok, check.Ok, check.Name)
+			assert.Contains(t, check.TechnicalSummary, expected[i].summary)
+		}
+	}
+
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka", httpmock.NewStringResponder(503, ""))
+	h.poller.poll()
+	gtgStatus := h.GTG()
+	assert.False(t, gtgStatus.GoodToGo)
+	assert.Equal(t, "Error retrieving cluster list.", gtgStatus.Message)
+}
+
+func TestConsumerStatusWithConfiguredThresholds(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	c, err := parseConfig([]byte(`
+thresholds:
+  - consumerGroup: image-*
+    maxLagTolerance: 50000
+  - topic: CmsPublicationEvents
+    maxLagTolerance: 100
+`))
+	assert.NoError(t, err)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, thresholds: newThresholds(1000, 30, 0, c.Thresholds)})
+
+	var testCases = []struct {
+		consumerGroup string
+		topic         string
+		totalLag      int64
+		err           string
+	}{
+		{consumerGroup: "image-publish", topic: "CmsPublicationEvents", totalLag: 20000, err: ""},
+		{consumerGroup: "image-publish", topic: "CmsPublicationEvents", totalLag: 60000, err: "image-publish consumer group is lagging behind with 60000 messages on CmsPublicationEvents (partition 0: 60000). Status of the consumer group is OK"},
+		{consumerGroup: "content-notifications", topic: "CmsPublicationEvents", totalLag: 101, err: "content-notifications consumer group is lagging behind with 101 messages on CmsPublicationEvents (partition 0: 101). Status of the consumer group is OK"},
+		{consumerGroup: "content-notifications", topic: "Concept", totalLag: 101, err: ""},
+	}
+
+	for _, tc := range testCases {
+		lag := tc.totalLag
+		status := &burrow.ConsumerGroupStatus{
+			Status:     burrow.StatusOK,
+			Partitions: []burrow.PartitionStatus{{Topic: tc.topic, Status: burrow.StatusOK, CurrentLag: &lag}},
+			TotalLag:   tc.totalLag,
+		}
+		_, err := checkConsumerGroupForLags(h.current(), status, tc.consumerGroup, lagTrends{})
+		if tc.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tc.err)
+		}
+	}
+}
+
+func TestConsumerStatusWithThresholdsPerTopic(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	c, err := parseConfig([]byte(`
+thresholds:
+  - topic: CmsPublicationEvents
+    maxLagTolerance: 100
+  - topic: Concept
+    maxLagTolerance: 5000
+`))
+	assert.NoError(t, err)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, thresholds: newThresholds(1000, -1, 0, c.Thresholds)})
+
+	partition := func(topic string, lag int64) burrow.PartitionStatus {
+		return burrow.PartitionStatus{Topic: topic, Status: burrow.StatusOK, CurrentLag: &lag}
+	}
+	var testCases = []struct {
+		description string
+		partitions  []burrow.PartitionStatus
+		err         string
+	}{
+		{
+			description: "each topic within its own limit",
+			partitions:  []burrow.PartitionStatus{partition("Concept", 4000), partition("CmsPublicationEvents", 50)},
+			err:         "",
+		},
+		{
+			description: "topic with the lower limit beyond it while lagging less",
+			partitions:  []burrow.PartitionStatus{partition("Concept", 4000), partition("CmsPublicationEvents", 150)},
+			err:         "methode-article-mapper consumer group is lagging behind with 150 messages on CmsPublicationEvents (partition 0: 150). Status of the consumer group is OK",
+		},
+		{
+			description: "topic with the higher limit beyond it",
+			partitions:  []burrow.PartitionStatus{partition("Concept", 6000), partition("CmsPublicationEvents", 50)},
+			err:         "methode-article-mapper consumer group is lagging behind with 6000 messages on Concept (partition 0: 6000). Status of the consumer group is OK",
+		},
+		{
+			description: "topics without rule within the limit of the consumer group",
+			partitions:  []burrow.PartitionStatus{partition("Concept", 4000), partition("NativeCmsPublicationEvents", 600), partition("CmsMetadataPublicationEvents", 300)},
+			err:         "",
+		},
+		{
+			description: "topics without rule beyond the limit of the consumer group together",
+			partitions:  []burrow.PartitionStatus{partition("Concept", 4000), partition("NativeCmsPublicationEvents", 800), partition("CmsMetadataPublicationEvents", 300)},
+			err:         "methode-article-mapper consumer group is lagging behind with 1100 messages on NativeCmsPublicationEvents (partition 0: 800), CmsMetadataPublicationEvents (partition 0: 300). Status of the consumer group is OK",
+		},
+	}
+
+	for _, tc := range testCases {
+		status := &burrow.ConsumerGroupStatus{Status: burrow.StatusOK, Partitions: tc.partitions}
+		for _, partition := range tc.partitions {
+			status.TotalLag += partition.Lag()
+		}
+		_, err := checkConsumerGroupForLags(h.current(), status, "methode-article-mapper", lagTrends{})
+		if tc.err == "" {
+			assert.NoError(t, err, tc.description)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.description)
+		}
+	}
+}
+
+func TestConsumerStatusWithTimeLag(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, filters: newTestFilters([]string{"Concept"}, []string{}), thresholds: newThresholds(-1, -1, 5*time.Minute, nil)})
+
+	millis := func(d time.Duration) int64 {
+		return time.Now().Add(-d).UnixNano() / int64(time.Millisecond)
+	}
+	stalled := func(topic string, partition int32) burrow.PartitionStatus {
+		return burrow.PartitionStatus{
+			Topic:     topic,
+			Partition: partition,
+			Status:    burrow.StatusStall,
+			Start:     &burrow.Offset{Offset: 100, Timestamp: millis(20 * time.Minute)},
+			End:       &burrow.Offset{Offset: 100, Timestamp: millis(time.Minute), Lag: 3},
+		}
+	}
+	catchingUp := burrow.PartitionStatus{
+		Topic:     "CmsPublicationEvents",
+		Partition: 0,
+		Status:    burrow.StatusOK,
+		Start:     &burrow.Offset{Offset: 100, Timestamp: millis(2 * time.Minute)},
+		End:       &burrow.Offset{Offset: 10100, Timestamp: millis(time.Minute), Lag: 20000},
+	}
+
+	var testCases = []struct {
+		description string
+		partitions  []burrow.PartitionStatus
+		err         string
+	}{
+		{
+			description: "many messages behind but catching up quickly",
+			partitions:  []burrow.PartitionStatus{catchingUp},
+			err:         "",
+		},
+		{
+			description: "few messages behind but stalled for long",
+			partitions:  []burrow.PartitionStatus{catchingUp, stalled("CmsPublicationEvents", 3)},
+			err:         "xp-notifications-push-2 consumer group is 20m0s behind on partition 3 of topic CmsPublicationEvents, more than the tolerated 5m0s. Status of the consumer group is ERR",
+		},
+		{
+			description: "stalled on a whitelisted topic",
+			partitions:  []burrow.PartitionStatus{stalled("Concept", 1)},
+			err:         "",
+		},
+	}
+
+	for _, tc := range testCases {
+		status := &burrow.ConsumerGroupStatus{
+			Status:     burrow.StatusError,
+			Partitions: tc.partitions,
+			TotalLag:   20003,
+		}
+		_, err := checkConsumerGroupForLags(h.current(), status, "xp-notifications-push-2", lagTrends{})
+		if tc.err == "" {
+			assert.NoError(t, err, tc.description)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.description)
+		}
+	}
+}
+
+func TestConsumerStatusLagPerTopic(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, filters: newTestFilters([]string{"Concept*"}, []string{}), thresholds: newThresholds(100, 5, 0, nil)})
+
+	partition := func(topic string, partition int32, status burrow.Status, lag int64) burrow.PartitionStatus {
+		return burrow.PartitionStatus{Topic: topic, Partition: partition, Status: status, CurrentLag: &lag}
+	}
+
+	var testCases = []struct {
+		description string
+		status      burrow.Status
+		partitions  []burrow.PartitionStatus
+		err         string
+	}{
+		{
+			description: "whitelisted topic lagging the most, checked topic within tolerance",
+			status:      burrow.StatusOK,
+			partitions: []burrow.PartitionStatus{
+				partition("ConceptAnnotations", 0, burrow.StatusOK, 5000),
+				partition("CmsPublicationEvents", 0, burrow.StatusOK, 60),
+			},
+			err: "",
+		},
+		{
+			description: "checked topic lagging beyond tolerance behind a more lagging whitelisted topic",
+			status:      burrow.StatusOK,
+			partitions: []burrow.PartitionStatus{
+				partition("ConceptAnnotations", 0, burrow.StatusOK, 5000),
+				partition("CmsPublicationEvents", 0, burrow.StatusOK, 70),
+				partition("CmsPublicationEvents", 1, burrow.StatusOK, 0),
+				partition("CmsPublicationEvents", 2, burrow.StatusOK, 80),
+				partition("CmsMetadataPublicationEvents", 0, burrow.StatusOK, 10),
+			},
+			err: "xp-notifications-push-2 consumer group is lagging behind with 160 messages on CmsPublicationEvents (partition 2: 80, partition 0: 70), CmsMetadataPublicationEvents (partition 0: 10), ignoring whitelisted ConceptAnnotations (partition 0: 5000). Status of the consumer group is OK",
+		},
+		{
+			description: "only partitions of whitelisted topics are not OK",
+			status:      burrow.StatusWarning,
+			partitions: []burrow.PartitionStatus{
+				partition("Concept", 0, burrow.StatusWarning, 500),
+				partition("CmsPublicationEvents", 0, burrow.StatusOK, 20),
+			},
+			err: "",
+		},
+		{
+			description: "partition of a checked topic is not OK",
+			status:      burrow.StatusWarning,
+			partitions: []burrow.PartitionStatus{
+				partition("Concept", 0, burrow.StatusOK, 500),
+				partition("CmsPublicationEvents", 3, burrow.StatusWarning, 20),
+			},
+			err: "xp-notifications-push-2 consumer group is lagging behind with 20 messages on CmsPublicationEvents (partition 3: 20), ignoring whitelisted Concept (partition 0: 500). Status of the consumer group is WARN",
+		},
+	}
+
+	for _, tc := range testCases {
+		status := &burrow.ConsumerGroupStatus{Status: tc.status, Partitions: tc.partitions}
+		for _, p := range tc.partitions {
+			status.TotalLag += p.Lag()
+		}
+		_, err := checkConsumerGroupForLags(h.current(), status, "xp-notifications-push-2", lagTrends{})
+		if tc.err == "" {
+			assert.NoError(t, err, tc.description)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.description)
+		}
+	}
+}
+
+func TestConsumerStatusWithLagTrend(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	c, err := parseConfig([]byte(`
+thresholds:
+  - topic: Concept*
+    maxLagTolerance: 100
+`))
+	assert.NoError(t, err)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, thresholds: newThresholds(100, 5, 0, c.Thresholds), trendWindow: 5 * time.Minute, maxDrainTime: time.Hour})
+
+	lag, conceptLag := int64(3000), int64(50)
+	status := &burrow.ConsumerGroupStatus{
+		Status: burrow.StatusWarning,
+		Partitions: []burrow.PartitionStatus{
+			{Topic: "CmsPublicationEvents", Partition: 0, Status: burrow.StatusWarning, CurrentLag: &lag},
+			{Topic: "ConceptAnnotations", Partition: 0, Status: burrow.StatusOK, CurrentLag: &conceptLag},
+		},
+		TotalLag: lag + conceptLag,
+	}
+	catchingUp := lagTrend{direction: trendDraining, rate: -100, eta: 30 * time.Second, catchingUp: true}
+
+	var testCases = []struct {
+		description string
+		trends      lagTrends
+		err         string
+	}{
+		{
+			description: "draining lag",
+			trends:      lagTrends{group: catchingUp},
+			err:         "",
+		},
+		{
+			description: "lag draining too slowly",
+			trends:      lagTrends{group: lagTrend{direction: trendDraining, rate: -0.5, eta: 100 * time.Minute}},
+			err:         "xp-notifications-push-2 consumer group is lagging behind with 3000 messages on CmsPublicationEvents (partition 0: 3000). Status of the consumer group is WARN. Lag is draining at 0.5 messages/s, caught up in about 1h40m0s",
+		},
+		{
+			description: "draining lag growing on a topic",
+			trends:      lagTrends{group: catchingUp, topics: map[string]lagTrend{"CmsPublicationEvents": {direction: trendGrowing, rate: 2}}},
+			err:         "xp-notifications-push-2 consumer group is lagging behind with 3000 messages on CmsPublicationEvents (partition 0: 3000). Status of the consumer group is WARN. Lag is draining at 100.0 messages/s, caught up in about 30s",
+		},
+		{
+			description: "growing lag",
+			trends:      lagTrends{group: lagTrend{direction: trendGrowing, rate: 12.5}},
+			err:         "xp-notifications-push-2 consumer group is lagging behind with 3000 messages on CmsPublicationEvents (partition 0: 3000). Status of the consumer group is WARN. Lag is growing at 12.5 messages/s",
+		},
+		{
+			description: "stable lag",
+			trends:      lagTrends{group: lagTrend{direction: trendStable}},
+			err:         "xp-notifications-push-2 consumer group is lagging behind with 3000 messages on CmsPublicationEvents (partition 0: 3000). Status of the consumer group is WARN. Lag is stable",
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := checkConsumerGroupForLags(h.current(), status, "xp-notifications-push-2", tc.trends)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.description)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.description)
+		}
+	}
+
+	// the topic with its own threshold is judged by its own trend
+	conceptLag = 150
+	topic, err := checkConsumerGroupForLags(h.current(), status, "xp-notifications-push-2", lagTrends{group: catchingUp, topics: map[string]lagTrend{"ConceptAnnotations": {direction: trendStable}}})
+	assert.Equal(t, "ConceptAnnotations", topic)
+	assert.EqualError(t, err, "xp-notifications-push-2 consumer group is lagging behind with 150 messages on ConceptAnnotations (partition 0: 150). Status of the consumer group is OK. Lag is stable")
+	_, err = checkConsumerGroupForLags(h.current(), status, "xp-notifications-push-2", lagTrends{group: catchingUp, topics: map[string]lagTrend{"ConceptAnnotations": catchingUp}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "draining at 100.0 messages/s, caught up in about 30s", catchingUp.String())
+}
+
+func TestGTGWithHysteresis(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"consumer1"},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+	statusResponder := func(totalLag int) httpmock.Responder {
+		responder, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+			"error":   false,
+			"message": "consumer group status returned",
+			"status": map[string]interface{}{
+				"status":          "OK",
+				"complete":        true,
+				"partitions":      []map[string]interface{}{},
+				"partition_count": 1,
+				"totallag":        totalLag,
+			},
+		})
+		return responder
+	}
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), hysteresis: newHysteresis(2, 0, 2)})
+
+	var testCases = []struct {
+		totalLag int
+		goodToGo bool
+	}{
+		{totalLag: 20, goodToGo: true},
+		{totalLag: 5, goodToGo: true},
+		{totalLag: 20, goodToGo: true},
+		{totalLag: 20, goodToGo: false},
+		{totalLag: 5, goodToGo: false},
+		{totalLag: 5, goodToGo: true},
+	}
+
+	for i, tc := range testCases {
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer1/lag", statusResponder(tc.totalLag))
+		h.poller.poll()
+		assert.Equal(t, tc.goodToGo, h.GTG().GoodToGo, "poll %d", i+1)
+	}
+}
+
+func TestSilencedConsumerGroup(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	now := time.Now()
+	s, err := newSilences("")
+	assert.NoError(t, err)
+	silence, err := s.add(silence{ConsumerGroup: mustParsePattern("consumer1"), Reason: "Reindexing", EndsAt: now.Add(time.Hour)}, now)
+	assert.NoError(t, err)
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), silences: s})
+	lagging := errors.New("consumer1 consumer group is lagging behind with 20 messages")
+
+	output, err := h.consumerLags(consumerGroupLag{cluster: "local", consumerGroup: "consumer1", err: lagging}, h.current().checks).Checker()
+	assert.NoError(t, err)
+	assert.Equal(t, "ok (silenced until "+silence.EndsAt.Format(time.RFC3339)+": Reindexing) consumer1 consumer group is lagging behind with 20 messages", output)
+
+	_, err = h.consumerLags(consumerGroupLag{cluster: "local", consumerGroup: "consumer2", err: lagging}, h.current().checks).Checker()
+	assert.Error(t, err, "consumer group not silenced")
+
+	_, err = s.expire(silence.ID, now)
+	assert.NoError(t, err)
+	_, err = h.consumerLags(consumerGroupLag{cluster: "local", consumerGroup: "consumer1", err: lagging}, h.current().checks).Checker()
+	assert.Error(t, err, "silence expired")
+}
+
+func TestConsumerLagsCheckMetadata(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	c, err := parseConfig([]byte(`
+checks:
+  - consumerGroup: image-*
+    severity: 2
+    team: images
+    businessImpact: Images won't be published.
+    panicGuide: https://runbooks.in.ft.com/image-publish
+    technicalSummary: "{{.ConsumerGroup}} is behind on {{.Topic}}."
+  - topic: Concept
+    team: concepts
+`))
+	assert.NoError(t, err)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), checks: newCheckRules(c.Checks)})
+	status := func(topic string) *burrow.ConsumerGroupStatus {
+		lag := int64(20)
+		return &burrow.ConsumerGroupStatus{
+			Status:     burrow.StatusOK,
+			Partitions: []burrow.PartitionStatus{{Topic: topic, CurrentLag: &lag, Status: burrow.StatusOK}},
+			TotalLag:   20,
+		}
+	}
+
+	check := h.consumerLags(consumerGroupLag{cluster: "local", consumerGroup: "image-publish", status: status("CmsPublicationEvents"), topic: "CmsPublicationEvents", severity: 3}, h.current().checks)
+	assert.Equal(t, uint8(2), check.Severity, "severity of the check rule overrides the threshold")
+	assert.Equal(t, "Images won't be published.", check.BusinessImpact)
+	assert.Equal(t, "https://runbooks.in.ft.com/image-publish", check.PanicGuide)
+	assert.Equal(t, "image-publish is behind on CmsPublicationEvents. Owned by images.", check.TechnicalSummary)
+
+	check = h.consumerLags(consumerGroupLag{cluster: "local", consumerGroup: "concept-publish", status: status("Concept"), topic: "Concept", severity: 3}, h.current().checks)
+	assert.Equal(t, uint8(3), check.Severity, "severity of the threshold applies without a check rule severity")
+	assert.Equal(t, "Will delay publishing on respective pipeline.", check.BusinessImpact)
+	assert.Equal(t, "https://runbooks.in.ft.com/kafka-lagcheck", check.PanicGuide)
+	assert.True(t, strings.HasSuffix(check.TechnicalSummary, "at the /__lag endpoint of kafka-lagcheck. Owned by concepts."), check.TechnicalSummary)
+}
+
+func TestConsumerLagsCheckMetadataOfBreachedTopic(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"methode-article-mapper"},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+	statusResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":   false,
+		"message": "consumer group status returned",
+		"status": map[string]interface{}{
+			"status":   "OK",
+			"complete": 1.0,
+			"partitions": []map[string]interface{}{
+				{"topic": "Concept", "partition": 0, "status": "OK", "current_lag": 4000},
+				{"topic": "CmsPublicationEvents", "partition": 0, "status": "OK", "current_lag": 150},
+			},
+			"partition_count": 2,
+			"maxlag":          nil,
+			"totallag":        4150,
+		},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/methode-article-mapper/lag", statusResponse)
+
+	c, err := parseConfig([]byte(`
+thresholds:
+  - topic: CmsPublicationEvents
+    maxLagTolerance: 100
+    severity: 2
+  - topic: Concept
+    maxLagTolerance: 5000
+    severity: 3
+checks:
+  - topic: CmsPublicationEvents
+    team: publishing
+    panicGuide: https://runbooks.in.ft.com/publishing
+  - topic: Concept
+    team: concepts
+    panicGuide: https://runbooks.in.ft.com/concepts
+`))
+	assert.NoError(t, err)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(1000, -1, 0, c.Thresholds), checks: newCheckRules(c.Checks)})
+
+	h.poller.poll()
+	lag := h.poller.current().clusters[0].consumerGroups[0]
+	assert.Equal(t, "CmsPublicationEvents", lag.topic, "the topic beyond its tolerance rather than the most lagging one")
+	check := h.consumerLags(lag, h.current().checks)
+	assert.Equal(t, uint8(2), check.Severity)
+	assert.Equal(t, "https://runbooks.in.ft.com/publishing", check.PanicGuide)
+	assert.True(t, strings.HasSuffix(check.TechnicalSummary, "Owned by publishing."), check.TechnicalSummary)
+	assert.Equal(t, "publishing", h.lagReport(h.poller.current()).Clusters[0].ConsumerGroups[0].Team)
+}
+
+func TestHealthJSONShape(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"Consumer.One", "consumer2"},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+	statusResponse := func(lag int) httpmock.Responder {
+		r, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+			"error":   false,
+			"message": "consumer group status returned",
+			"status": map[string]interface{}{
+				"status":   "OK",
+				"complete": 1.0,
+				"partitions": []map[string]interface{}{
+					{"topic": "CmsPublicationEvents", "partition": 0, "status": "OK", "current_lag": lag},
+				},
+				"partition_count": 1,
+				"maxlag":          nil,
+				"totallag":        lag,
+			},
+		})
+		return r
+	}
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/Consumer.One/lag", statusResponse(3))
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer2/lag", statusResponse(50))
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil)})
+
+	w := httptest.NewRecorder()
+	h.Health()(w, httptest.NewRequest("GET", "http://localhost/__health", nil))
+	var result struct {
+		Checks []map[string]interface{} `json:"checks"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	if assert.Len(t, result.Checks, 1) {
+		assert.Equal(t, "kafka-lagcheck-lag-snapshot", result.Checks[0]["id"])
+		assert.Equal(t, "Consumer group lags not yet available.", result.Checks[0]["checkOutput"])
+	}
+
+	h.poller.poll()
+	snapshot := h.poller.current()
+	fetchedAt := snapshot.fetchedAt.UTC().Format(time.RFC3339Nano)
+	failingSince := snapshot.clusters[0].consumerGroups[1].flap.firstFailed.UTC().Format(time.RFC3339)
+	w = httptest.NewRecorder()
+	h.Health()(w, httptest.NewRequest("GET", "http://localhost/__health", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+
+	expected := []map[string]interface{}{
+		{
+			"id":               "kafka-lagcheck-consumer-group-lag-local-consumer-one-9098bc0f",
+			"name":             "Consumer group Consumer.One on cluster local is lagging.",
+			"ok":               true,
+			"severity":         1.0,
+			"businessImpact":   "Will delay publishing on respective pipeline.",
+			"technicalSummary": "Consumer group Consumer.One on cluster local is lagging. Further info at: __burrow/v3/kafka/local/consumer/Consumer.One/lag and, per partition, at the /__lag endpoint of kafka-lagcheck.",
+			"panicGuide":       "https://runbooks.in.ft.com/kafka-lagcheck",
+			"checkOutput":      "Lag of 3 messages on CmsPublicationEvents (partition 0: 3). Status of the consumer group is OK",
+			"lastUpdated":      fetchedAt,
+		},
+		{
+			"id":               "kafka-lagcheck-consumer-group-lag-local-consumer2",
+			"name":             "Consumer group consumer2 on cluster local is lagging.",
+			"ok":               false,
+			"severity":         1.0,
+			"businessImpact":   "Will delay publishing on respective pipeline.",
+			"technicalSummary": "Consumer group consumer2 on cluster local is lagging. Further info at: __burrow/v3/kafka/local/consumer/consumer2/lag and, per partition, at the /__lag endpoint of kafka-lagcheck.",
+			"panicGuide":       "https://runbooks.in.ft.com/kafka-lagcheck",
+			"checkOutput":      "consumer2 consumer group is lagging behind with 50 messages on CmsPublicationEvents (partition 0: 50). Status of the consumer group is OK. Failing since " + failingSince + ", 1 consecutive failed evaluations",
+			"lastUpdated":      fetchedAt,
+		},
+	}
+	assert.Equal(t, expected, result.Checks)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://localhost/__health", nil)
+	r.Header.Set("Accept", "text/html")
+	h.Health()(w, r)
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "Last updated: "+fetchedAt), "the same time as in the JSON")
+	assert.Contains(t, w.Body.String(), "Consumer group consumer2 on cluster local is lagging.")
+}
+
+func TestCheckIDsDontCollide(t *testing.T) {
+	assert.Equal(t, "kafka-lagcheck-consumer-group-lag-local-content-notifications", checkID("consumer-group-lag", "local", "content-notifications"))
+
+	ids := map[string]string{}
+	for _, consumerGroup := range []string{"content-notifications", "content.notifications", "content_notifications", "Content-Notifications", "content-notifications-"} {
+		id := checkID("consumer-group-lag", "local", consumerGroup)
+		assert.Regexp(t, "^[a-z0-9-]+$", id)
+		assert.NotContains(t, ids, id, "%s has the ID of %s", consumerGroup, ids[id])
+		ids[id] = consumerGroup
+		assert.Equal(t, id, checkID("consumer-group-lag", "local", consumerGroup), "stable")
+	}
+}
+
+func TestConsumerGroupTimingOutIsUnknown(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/kafka/local/consumer":
+			w.Write([]byte(`{"error": false, "consumers": ["consumer1", "hanging"]}`))
+		case "/v3/kafka/local/consumer/consumer1/lag":
+			w.Write([]byte(`{"error": false, "status": {"status": "OK", "totallag": 50, "partitions": []}}`))
+		case "/v3/kafka/local/consumer/hanging/lag":
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(server.URL), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), pool: newWorkerPool(1, 50*time.Millisecond)})
+	h.poller.poll()
+
+	checks := h.checks()
+	if assert.Len(t, checks, 2) {
+		_, err := checks[0].Checker()
+		assert.Error(t, err, "consumer1 is lagging")
+		output, err := checks[1].Checker()
+		assert.NoError(t, err, "hanging is unknown rather than failed")
+		assert.True(t, strings.HasPrefix(output, "Lag unknown: "), output)
+	}
+	assert.False(t, h.GTG().GoodToGo)
+
+	report := h.consumerGroupReport(h.poller.current().clusters[0].consumerGroups[1], h.current().checks)
+	assert.True(t, report.Unknown)
+	assert.False(t, report.Healthy)
+	assert.NotEmpty(t, report.Error)
+}
+
+func TestHealthcheckDefaultPollInterval(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient("http://burrow.example.com"), thresholds: newThresholds(10, 5, 0, nil)})
+	assert.Equal(t, defaultPollInterval, h.poller.interval)
+	assert.NotPanics(t, func() {
+		h.poller.start()
+		h.poller.stop()
+	})
+}
+
+func TestBurrowRetriesHangingRequest(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/kafka/local/consumer":
+			w.Write([]byte(`{"error": false, "consumers": ["consumer1"]}`))
+		case "/v3/kafka/local/consumer/consumer1/lag":
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-r.Context().Done()
+				return
+			}
+			w.Write([]byte(`{"error": false, "status": {"status": "OK", "totallag": 0, "partitions": []}}`))
+		}
+	}))
+	defer server.Close()
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(server.URL), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), pool: newWorkerPool(1, 50*time.Millisecond), retries: newBackoff(1, time.Millisecond)})
+	h.poller.poll()
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls), "the attempt that timed out is made again with a deadline of its own")
+	assert.True(t, h.GTG().GoodToGo)
+}
+
+func TestBurrowRetryReleasesPoolSlot(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	h := newHealthcheck(healthcheckOptions{pool: newWorkerPool(1, 0), retries: newBackoff(1, time.Second)})
+	failed := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		attempts := 0
+		done <- h.requestBurrow(context.Background(), "flaky", func(ctx context.Context) error {
+			attempts++
+			if attempts == 1 {
+				close(failed)
+				return &burrow.Error{StatusCode: 500}
+			}
+			return nil
+		})
+	}()
+	<-failed
+
+	start := time.Now()
+	assert.NoError(t, h.requestBurrow(context.Background(), "other", func(ctx context.Context) error { return nil }))
+	assert.Less(t, time.Since(start), 400*time.Millisecond, "the slot is free while the flaky request waits to be retried")
+	assert.NoError(t, <-done)
+}
+
+func TestBurrowRetriesAndCircuitBreaker(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"consumer1", "consumer2", "consumer3"},
+	})
+	okResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":   false,
+		"message": "consumer group status returned",
+		"status":  map[string]interface{}{"status": "OK", "partitions": []map[string]interface{}{}, "totallag": 0},
+	})
+	flaky := func() httpmock.Responder {
+		calls := 0
+		return func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return httpmock.NewStringResponse(500, ""), nil
+			}
+			return okResponse(req)
+		}
+	}
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+	// The first attempts of the flaky consumer groups may fail in a row, as no slot is held while waiting to retry.
+	for _, consumer := range []string{"consumer1", "consumer2"} {
+		httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/"+consumer+"/lag", flaky())
+	}
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer3/lag", okResponse)
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), pool: newWorkerPool(1, 0), retries: newBackoff(2, time.Millisecond), breaker: newCircuitBreaker(3, time.Minute)})
+	h.poller.poll()
+	assert.True(t, h.GTG().GoodToGo, "transient failures are retried")
+	assert.Len(t, h.checks(), 3)
+
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", httpmock.NewStringResponder(503, ""))
+	h.poller.poll()
+	checks := h.checks()
+	if assert.Len(t, checks, 1, "a single check while Burrow is unavailable") {
+		assert.Equal(t, "kafka-lagcheck-burrow", checks[0].ID)
+		_, err := checks[0].Checker()
+		assert.Regexp(t, "^Burrow is unavailable, circuit breaker open since .* after 3 consecutive failed requests, next attempt at .*\\.$", err.Error())
+	}
+	assert.False(t, h.GTG().GoodToGo)
+}
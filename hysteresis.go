@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// hysteresis smooths the outcome of the lag checks of consumer groups hovering around their tolerances: a consumer group
+// is reported unhealthy only after failAfter consecutive failing evaluations spanning at least failAfterDuration, and
+// healthy again only after recoverAfter consecutive passing ones.
+type hysteresis struct {
+	failAfter         int
+	failAfterDuration time.Duration
+	recoverAfter      int
+
+	mu     sync.Mutex
+	states map[flapKey]*flapState
+}
+
+type flapKey struct {
+	cluster       string
+	consumerGroup string
+}
+
+// flapState is the state of a consumer group. lastErr is the error of the last failing evaluation.
+type flapState struct {
+	unhealthy     bool
+	failStreak    int
+	passStreak    int
+	firstFailed   time.Time
+	lastErr       error
+	lastEvaluated time.Time
+}
+
+// flapStatus is the outcome of an evaluation. err is the error to report, nil while a failing consumer group is not yet
+// reported unhealthy, and output describes the streaks.
+type flapStatus struct {
+	err         error
+	output      string
+	failStreak  int
+	passStreak  int
+	firstFailed time.Time
+}
+
+func newHysteresis(failAfter int, failAfterDuration time.Duration, recoverAfter int) *hysteresis {
+	if failAfter < 1 {
+		failAfter = 1
+	}
+	if recoverAfter < 1 {
+		recoverAfter = 1
+	}
+	return &hysteresis{
+		failAfter:         failAfter,
+		failAfterDuration: failAfterDuration,
+		recoverAfter:      recoverAfter,
+		states:            map[flapKey]*flapState{},
+	}
+}
+
+// evaluate records the outcome of a lag check of the consumer group and returns what has to be reported.
+func (h *hysteresis) evaluate(cluster string, consumerGroup string, err error, now time.Time) flapStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := flapKey{cluster: cluster, consumerGroup: consumerGroup}
+	state, found := h.states[key]
+	if !found {
+		state = &flapState{}
+		h.states[key] = state
+	}
+	state.lastEvaluated = now
+
+	if err != nil {
+		if state.failStreak == 0 && !state.unhealthy {
+			state.firstFailed = now
+		}
+		state.failStreak++
+		state.passStreak = 0
+		state.lastErr = err
+		if state.failStreak >= h.failAfter && now.Sub(state.firstFailed) >= h.failAfterDuration {
+			state.unhealthy = true
+		}
+	} else {
+		state.passStreak++
+		state.failStreak = 0
+		if state.passStreak >= h.recoverAfter {
+			state.unhealthy = false
+		}
+	}
+
+	status := flapStatus{failStreak: state.failStreak, passStreak: state.passStreak}
+	if err != nil || state.unhealthy {
+		status.firstFailed = state.firstFailed
+	}
+	since := state.firstFailed.UTC().Format(time.RFC3339)
+	switch {
+	case state.unhealthy && err != nil:
+		status.err = fmt.Errorf("%v. Failing since %s, %d consecutive failed evaluations", err, since, state.failStreak)
+	case state.unhealthy:
+		status.err = fmt.Errorf("%v. Recovering, passed %d of %d evaluations, failing since %s", state.lastErr, state.passStreak, h.recoverAfter, since)
+	case err != nil:
+		status.output = fmt.Sprintf("Failed %d consecutive evaluations since %s, not yet reported: %v", state.failStreak, since, err)
+	}
+	return status
+}
+
+// keep marks the consumer group as evaluated without changing its streaks, so that it isn't pruned.
+func (h *hysteresis) keep(cluster string, consumerGroup string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if state, found := h.states[flapKey{cluster: cluster, consumerGroup: consumerGroup}]; found {
+		state.lastEvaluated = now
+	}
+}
+
+// prune forgets the consumer groups of the cluster that were not evaluated since the given time.
+func (h *hysteresis) prune(cluster string, since time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, state := range h.states {
+		if key.cluster == cluster && state.lastEvaluated.Before(since) {
+			delete(h.states, key)
+		}
+	}
+}
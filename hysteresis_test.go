@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHysteresis(t *testing.T) {
+	h := newHysteresis(3, 0, 2)
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	lagging := errors.New("consumer1 consumer group is lagging behind with 45 messages")
+
+	var testCases = []struct {
+		err        error
+		healthy    bool
+		failStreak int
+		passStreak int
+		message    string
+	}{
+		{err: lagging, healthy: true, failStreak: 1, message: "Failed 1 consecutive evaluations since 2017-10-01T12:00:00Z, not yet reported: consumer1 consumer group is lagging behind with 45 messages"},
+		{err: nil, healthy: true, passStreak: 1, message: ""},
+		{err: lagging, healthy: true, failStreak: 1, message: "Failed 1 consecutive evaluations since 2017-10-01T12:02:00Z, not yet reported: consumer1 consumer group is lagging behind with 45 messages"},
+		{err: lagging, healthy: true, failStreak: 2, message: "Failed 2 consecutive evaluations since 2017-10-01T12:02:00Z, not yet reported: consumer1 consumer group is lagging behind with 45 messages"},
+		{err: lagging, healthy: false, failStreak: 3, message: "consumer1 consumer group is lagging behind with 45 messages. Failing since 2017-10-01T12:02:00Z, 3 consecutive failed evaluations"},
+		{err: nil, healthy: false, passStreak: 1, message: "consumer1 consumer group is lagging behind with 45 messages. Recovering, passed 1 of 2 evaluations, failing since 2017-10-01T12:02:00Z"},
+		{err: lagging, healthy: false, failStreak: 1, message: "consumer1 consumer group is lagging behind with 45 messages. Failing since 2017-10-01T12:02:00Z, 1 consecutive failed evaluations"},
+		{err: nil, healthy: false, passStreak: 1, message: "consumer1 consumer group is lagging behind with 45 messages. Recovering, passed 1 of 2 evaluations, failing since 2017-10-01T12:02:00Z"},
+		{err: nil, healthy: true, passStreak: 2, message: ""},
+	}
+
+	for i, tc := range testCases {
+		status := h.evaluate("local", "consumer1", tc.err, start.Add(time.Duration(i)*time.Minute))
+		assert.Equal(t, tc.healthy, status.err == nil, "evaluation %d", i+1)
+		assert.Equal(t, tc.failStreak, status.failStreak, "evaluation %d", i+1)
+		assert.Equal(t, tc.passStreak, status.passStreak, "evaluation %d", i+1)
+		if status.err != nil {
+			assert.Equal(t, tc.message, status.err.Error(), "evaluation %d", i+1)
+		} else {
+			assert.Equal(t, tc.message, status.output, "evaluation %d", i+1)
+		}
+	}
+}
+
+func TestHysteresisDuration(t *testing.T) {
+	h := newHysteresis(1, 5*time.Minute, 1)
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	lagging := errors.New("lagging")
+
+	assert.NoError(t, h.evaluate("local", "consumer1", lagging, start).err)
+	assert.NoError(t, h.evaluate("local", "consumer1", lagging, start.Add(4*time.Minute)).err)
+	assert.Error(t, h.evaluate("local", "consumer1", lagging, start.Add(5*time.Minute)).err)
+	assert.NoError(t, h.evaluate("local", "consumer2", lagging, start.Add(5*time.Minute)).err, "other consumer group")
+	assert.NoError(t, h.evaluate("local", "consumer1", nil, start.Add(6*time.Minute)).err)
+
+	h.prune("local", start.Add(5*time.Minute))
+	assert.Len(t, h.states, 2)
+	h.prune("remote", start.Add(time.Hour))
+	assert.Len(t, h.states, 2, "other cluster")
+	h.prune("local", start.Add(6*time.Minute))
+	assert.Len(t, h.states, 1)
+}
+
+func TestHysteresisDefaults(t *testing.T) {
+	h := newHysteresis(0, 0, 0)
+	lagging := errors.New("lagging")
+
+	status := h.evaluate("local", "consumer1", lagging, time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC))
+	assert.EqualError(t, status.err, "lagging. Failing since 2017-10-01T12:00:00Z, 1 consecutive failed evaluations")
+	assert.NoError(t, h.evaluate("local", "consumer1", nil, time.Now()).err)
+}
+
+func TestHysteresisKeep(t *testing.T) {
+	h := newHysteresis(2, 0, 1)
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	lagging := errors.New("lagging")
+
+	assert.NoError(t, h.evaluate("local", "consumer1", lagging, start).err)
+	h.keep("local", "consumer1", start.Add(time.Minute))
+	h.keep("local", "consumer2", start.Add(time.Minute))
+	h.prune("local", start.Add(time.Minute))
+	assert.Len(t, h.states, 1, "kept consumer group is not pruned, unknown one is not added")
+	assert.Error(t, h.evaluate("local", "consumer1", lagging, start.Add(2*time.Minute)).err, "streak unchanged by keep")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Names of the lag sources.
+const (
+	lagSourceBurrow = "burrow"
+	lagSourceKafka  = "kafka"
+)
+
+// lagSource provides the lags of the consumer groups, as Burrow evaluates them or as computed from Kafka itself.
+type lagSource interface {
+	// Clusters lists the names of the Kafka clusters whose consumer groups can be checked.
+	Clusters(ctx context.Context) ([]string, error)
+	// Consumers lists the consumer groups of a cluster.
+	Consumers(ctx context.Context, cluster string) ([]string, error)
+	// ConsumerLag returns the status of a consumer group, listing all of its partitions.
+	ConsumerLag(ctx context.Context, cluster string, group string) (*burrow.ConsumerGroupStatus, error)
+}
+
+var _ lagSource = (*burrow.Client)(nil)
+
+// kafkaLagSource computes the lags of the consumer groups of a single cluster from Kafka directly: the lag on a partition
+// is its log end offset minus the offset committed by the consumer group, and unknown for a partition assigned to it but
+// not committed to yet. Unlike Burrow it keeps no offset history, so every consumer group has status OK and no time lag
+// can be estimated.
+type kafkaLagSource struct {
+	cluster string
+	admin   *kadm.Client
+}
+
+func newKafkaLagSource(cluster string, admin *kadm.Client) *kafkaLagSource {
+	return &kafkaLagSource{cluster: cluster, admin: admin}
+}
+
+// newKafkaAdmin creates the client of the cluster the seed brokers (host:port) belong to. It makes a single attempt per
+// request, as the requests for lags are retried like those to Burrow.
+func newKafkaAdmin(seeds []string, dialTimeout time.Duration) (*kadm.Client, error) {
+	client, err := kgo.NewClient(kgo.SeedBrokers(seeds...), kgo.ClientID(systemCode), kgo.DialTimeout(dialTimeout), kgo.RequestRetries(0))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create the Kafka client: %w", err)
+	}
+	return kadm.NewClient(client), nil
+}
+
+// errNoCommittedOffset is the cause of the unknown lag on a partition the consumer group hasn't committed an offset on.
+var errNoCommittedOffset = errors.New("no offset committed")
+
+// unknownLagError is returned when the lag of a consumer group on a partition couldn't be computed, typically because
+// its log end offset couldn't be listed or it has no committed offset. The lag of the consumer group is then unknown
+// rather than partly left out.
+type unknownLagError struct {
+	topic     string
+	partition int32
+	err       error
+}
+
+func (e *unknownLagError) Error() string {
+	return fmt.Sprintf("Could not compute the lag on partition %d of topic %s: %v", e.partition, e.topic, e.err)
+}
+
+func (e *unknownLagError) Unwrap() error {
+	return e.err
+}
+
+func (s *kafkaLagSource) Clusters(ctx context.Context) ([]string, error) {
+	return []string{s.cluster}, nil
+}
+
+func (s *kafkaLagSource) Consumers(ctx context.Context, cluster string) ([]string, error) {
+	if err := s.checkCluster(cluster); err != nil {
+		return nil, err
+	}
+	listed, err := s.admin.ListGroups(ctx)
+	if err != nil {
+		// the consumer groups of the brokers that failed would look gone
+		return nil, fmt.Errorf("Could not list the consumer groups: %w", shardError(err))
+	}
+	var groups []string
+	for _, group := range listed.Sorted() {
+		if group.ProtocolType == "consumer" || group.ProtocolType == "" {
+			groups = append(groups, group.Group)
+		}
+	}
+	return groups, nil
+}
+
+func (s *kafkaLagSource) ConsumerLag(ctx context.Context, cluster string, group string) (*burrow.ConsumerGroupStatus, error) {
+	if err := s.checkCluster(cluster); err != nil {
+		return nil, err
+	}
+	described, err := s.admin.DescribeGroups(ctx, group)
+	if err == nil {
+		err = described.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not describe consumer group %s: %w", group, shardError(err))
+	}
+	committed, err := s.admin.FetchOffsets(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch the offsets of consumer group %s: %w", group, err)
+	}
+	partitions := described.AssignedPartitions()
+	partitions.Merge(committed.Partitions())
+	var end kadm.ListedOffsets
+	if topics := partitions.Topics(); len(topics) > 0 {
+		end, err = s.admin.ListEndOffsets(ctx, topics...)
+		var shardErrs *kadm.ShardErrors
+		// the partitions of the brokers that failed are missing from the end offsets, so their lag is unknown
+		if err != nil && !errors.As(err, &shardErrs) {
+			return nil, fmt.Errorf("Could not list the end offsets of the topics of consumer group %s: %w", group, err)
+		}
+	}
+
+	status := &burrow.ConsumerGroupStatus{Cluster: cluster, Group: group, Status: burrow.StatusOK, Complete: 1}
+	for _, partitionLag := range kadm.CalculateGroupLag(described[group], committed, end).Sorted() {
+		if partitionLag.Err != nil {
+			return nil, &unknownLagError{topic: partitionLag.Topic, partition: partitionLag.Partition, err: partitionLag.Err}
+		}
+		if partitionLag.Commit.At < 0 {
+			return nil, &unknownLagError{topic: partitionLag.Topic, partition: partitionLag.Partition, err: errNoCommittedOffset}
+		}
+		lag := partitionLag.Lag
+		status.Partitions = append(status.Partitions, burrow.PartitionStatus{
+			Topic:      partitionLag.Topic,
+			Partition:  partitionLag.Partition,
+			Status:     burrow.StatusOK,
+			End:        &burrow.Offset{Offset: partitionLag.Commit.At, Lag: lag},
+			Complete:   1,
+			CurrentLag: &lag,
+		})
+		status.TotalLag += lag
+	}
+	status.PartitionCount = len(status.Partitions)
+	for i := range status.Partitions {
+		if status.MaxLag == nil || status.Partitions[i].Lag() > status.MaxLag.Lag() {
+			maxLag := status.Partitions[i]
+			status.MaxLag = &maxLag
+		}
+	}
+	return status, nil
+}
+
+// shardError returns the error of the first request that failed among those kadm sent to several brokers, which it
+// reports without wrapping them.
+func shardError(err error) error {
+	var shardErrs *kadm.ShardErrors
+	if errors.As(err, &shardErrs) && len(shardErrs.Errs) > 0 {
+		return shardErrs.Errs[0].Err
+	}
+	return err
+}
+
+func (s *kafkaLagSource) checkCluster(cluster string) error {
+	if cluster != s.cluster {
+		return fmt.Errorf("Unknown cluster %s, only %s is checked directly in Kafka", cluster, s.cluster)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// newTestKafkaCluster starts a fake single broker Kafka cluster with the topics, each having one partition per log end
+// offset it is filled up to.
+func newTestKafkaCluster(t *testing.T, topics map[string][]int64) *kfake.Cluster {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(cluster.Close)
+	for topic, offsets := range topics {
+		createTestTopic(t, cluster, topic, offsets...)
+	}
+	return cluster
+}
+
+func createTestTopic(t *testing.T, cluster *kfake.Cluster, topic string, offsets ...int64) {
+	client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.RecordPartitioner(kgo.ManualPartitioner()))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+	ctx := context.Background()
+	_, err = kadm.NewClient(client).CreateTopic(ctx, int32(len(offsets)), 1, nil, topic)
+	assert.NoError(t, err)
+	var records []*kgo.Record
+	for partition, offset := range offsets {
+		for i := int64(0); i < offset; i++ {
+			records = append(records, &kgo.Record{Topic: topic, Partition: int32(partition)})
+		}
+	}
+	assert.NoError(t, client.ProduceSync(ctx, records...).FirstErr())
+}
+
+// commitTestOffsets commits the offsets of a consumer group on partitions of topics, as the member of the group it joins
+// for it, leaving the group empty again afterwards.
+func commitTestOffsets(t *testing.T, cluster *kfake.Cluster, group string, offsets map[string]map[int32]int64) {
+	var topics []string
+	uncommitted := map[string]map[int32]kgo.EpochOffset{}
+	for topic, partitions := range offsets {
+		topics = append(topics, topic)
+		uncommitted[topic] = map[int32]kgo.EpochOffset{}
+		for partition, offset := range partitions {
+			uncommitted[topic][partition] = kgo.EpochOffset{Epoch: -1, Offset: offset}
+		}
+	}
+	assigned := make(chan struct{})
+	client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumerGroup(group), kgo.ConsumeTopics(topics...),
+		kgo.DisableAutoCommit(), kgo.OnPartitionsAssigned(func(context.Context, *kgo.Client, map[string][]int32) { close(assigned) }))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+	select {
+	case <-assigned:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Consumer group %s wasn't joined in time", group)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client.CommitOffsetsSync(ctx, uncommitted, func(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+		assert.NoError(t, err)
+		if resp == nil {
+			return
+		}
+		for _, topic := range resp.Topics {
+			for _, partition := range topic.Partitions {
+				assert.NoError(t, kerr.ErrorForCode(partition.ErrorCode))
+			}
+		}
+	})
+}
+
+// failListOffsets makes the cluster close the connection of the next ListOffsets request for the topic, as a broker
+// going down would, so that the end offsets of its partitions are missing.
+func failListOffsets(cluster *kfake.Cluster, topic string) {
+	cluster.ControlKey(int16(kmsg.ListOffsets), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		for _, listed := range req.(*kmsg.ListOffsetsRequest).Topics {
+			if listed.Topic == topic {
+				return nil, errors.New("broker down"), true
+			}
+		}
+		return nil, nil, false
+	})
+}
+
+func newTestKafkaAdmin(t *testing.T, cluster *kfake.Cluster) *kadm.Client {
+	admin, err := newKafkaAdmin(cluster.ListenAddrs(), time.Second)
+	assert.NoError(t, err)
+	t.Cleanup(admin.Close)
+	return admin
+}
+
+func TestKafkaLagSource(t *testing.T) {
+	cluster := newTestKafkaCluster(t, map[string][]int64{"CmsPublicationEvents": {120, 80}, "Concept": {60}})
+	commitTestOffsets(t, cluster, "content-notifications", map[string]map[int32]int64{"CmsPublicationEvents": {0: 100, 1: 80}, "Concept": {0: 30}})
+	commitTestOffsets(t, cluster, "idle", map[string]map[int32]int64{"Concept": {0: 60}})
+	source := newKafkaLagSource("local", newTestKafkaAdmin(t, cluster))
+	ctx := context.Background()
+
+	clusters, err := source.Clusters(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"local"}, clusters)
+
+	consumers, err := source.Consumers(ctx, "local")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"content-notifications", "idle"}, consumers)
+
+	_, err = source.Consumers(ctx, "remote")
+	assert.EqualError(t, err, "Unknown cluster remote, only local is checked directly in Kafka")
+
+	status, err := source.ConsumerLag(ctx, "local", "content-notifications")
+	assert.NoError(t, err)
+	assert.Equal(t, burrow.StatusOK, status.Status)
+	assert.Equal(t, int64(50), status.TotalLag)
+	assert.Equal(t, 3, status.PartitionCount)
+	if assert.NotNil(t, status.MaxLag) {
+		assert.Equal(t, "Concept", status.MaxLag.Topic)
+		assert.Equal(t, int64(30), status.MaxLag.Lag())
+	}
+	var lags []int64
+	for _, partition := range status.Partitions {
+		lags = append(lags, partition.Lag())
+		assert.Equal(t, partition.Lag(), partition.End.Lag)
+	}
+	assert.Equal(t, []int64{20, 0, 30}, lags)
+	assert.Equal(t, int64(100), status.Partitions[0].End.Offset)
+}
+
+func TestHealthcheckWithKafkaLagSource(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	cluster := newTestKafkaCluster(t, map[string][]int64{"CmsPublicationEvents": {120, 80}, "Concept": {60}})
+	commitTestOffsets(t, cluster, "content-notifications", map[string]map[int32]int64{"CmsPublicationEvents": {0: 100, 1: 80}})
+	commitTestOffsets(t, cluster, "concept-reader", map[string]map[int32]int64{"Concept": {0: 58}})
+
+	h := newHealthcheck(healthcheckOptions{source: newKafkaLagSource("local", newTestKafkaAdmin(t, cluster)), thresholds: newThresholds(10, 5, 0, nil), pool: newWorkerPool(2, time.Second)})
+	h.poller.poll()
+
+	checks := h.checks()
+	if assert.Len(t, checks, 2) {
+		output, err := checks[0].Checker()
+		assert.NoError(t, err, "concept-reader is 2 messages behind")
+		assert.True(t, strings.HasPrefix(output, "Lag of 2 messages"), output)
+		_, err = checks[1].Checker()
+		if assert.Error(t, err) {
+			assert.True(t, strings.HasPrefix(err.Error(), "content-notifications consumer group is lagging behind with 20 messages on CmsPublicationEvents (partition 0: 20)."), err.Error())
+		}
+	}
+	assert.False(t, h.GTG().GoodToGo)
+
+	commitTestOffsets(t, cluster, "content-notifications", map[string]map[int32]int64{"CmsPublicationEvents": {0: 120}})
+	h.poller.poll()
+	assert.True(t, h.GTG().GoodToGo)
+
+	failListOffsets(cluster, "CmsPublicationEvents")
+	h.poller.poll()
+	output, err := h.checks()[1].Checker()
+	assert.NoError(t, err, "the lag on a partition without end offset is unknown rather than 0")
+	assert.Equal(t, "Lag unknown: Could not compute the lag on partition 0 of topic CmsPublicationEvents: missing from list offsets", output)
+	assert.Equal(t, groupUnknown, groupStatusOf(h.lagReport(h.poller.current()).Clusters[0].ConsumerGroups[1]))
+}
+
+func TestKafkaLagSourceUnknownLag(t *testing.T) {
+	cluster := newTestKafkaCluster(t, map[string][]int64{"CmsPublicationEvents": {120, 80}, "Deleted": {10}})
+	commitTestOffsets(t, cluster, "content-notifications", map[string]map[int32]int64{"CmsPublicationEvents": {0: 100, 1: 80}})
+	commitTestOffsets(t, cluster, "deleted-reader", map[string]map[int32]int64{"Deleted": {0: 10}})
+	admin := newTestKafkaAdmin(t, cluster)
+	source := newKafkaLagSource("local", admin)
+	ctx := context.Background()
+
+	failListOffsets(cluster, "CmsPublicationEvents")
+	_, err := source.ConsumerLag(ctx, "local", "content-notifications")
+	var unknownLag *unknownLagError
+	if assert.True(t, errors.As(err, &unknownLag), "%v", err) {
+		assert.Equal(t, "CmsPublicationEvents", unknownLag.topic)
+		assert.Equal(t, int32(0), unknownLag.partition)
+	}
+
+	status, err := source.ConsumerLag(ctx, "local", "content-notifications")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), status.TotalLag)
+
+	_, err = admin.DeleteTopics(ctx, "Deleted")
+	assert.NoError(t, err)
+	_, err = source.ConsumerLag(ctx, "local", "deleted-reader")
+	assert.True(t, errors.As(err, &unknownLag), "%v", err)
+	assert.True(t, errors.Is(err, kerr.UnknownTopicOrPartition), "%v", err)
+}
+
+func TestKafkaLagSourceNoCommittedOffset(t *testing.T) {
+	cluster := newTestKafkaCluster(t, map[string][]int64{"Concept": {60, 40}})
+	client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumerGroup("concept-reader"), kgo.ConsumeTopics("Concept"), kgo.DisableAutoCommit())
+	assert.NoError(t, err)
+	defer client.Close()
+	source := newKafkaLagSource("local", newTestKafkaAdmin(t, cluster))
+
+	var status *burrow.ConsumerGroupStatus
+	assert.Eventually(t, func() bool {
+		status, err = source.ConsumerLag(context.Background(), "local", "concept-reader")
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond, "the partitions assigned to concept-reader have no committed offset")
+	var unknownLag *unknownLagError
+	if assert.True(t, errors.As(err, &unknownLag), "%v", err) {
+		assert.Equal(t, "Concept", unknownLag.topic)
+		assert.Equal(t, int32(0), unknownLag.partition)
+	}
+	assert.True(t, errors.Is(err, errNoCommittedOffset), "%v", err)
+	assert.Nil(t, status)
+}
+
+func TestKafkaLagSourceErrors(t *testing.T) {
+	cluster := newTestKafkaCluster(t, map[string][]int64{"Concept": {50}})
+	commitTestOffsets(t, cluster, "concept-reader", map[string]map[int32]int64{"Concept": {0: 50}})
+	source := newKafkaLagSource("local", newTestKafkaAdmin(t, cluster))
+
+	cluster.ControlKey(int16(kmsg.OffsetFetch), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		fetch := req.(*kmsg.OffsetFetchRequest)
+		resp := fetch.ResponseKind().(*kmsg.OffsetFetchResponse)
+		resp.ErrorCode = kerr.GroupAuthorizationFailed.Code
+		for _, group := range fetch.Groups {
+			fetched := kmsg.NewOffsetFetchResponseGroup()
+			fetched.Group = group.Group
+			fetched.ErrorCode = kerr.GroupAuthorizationFailed.Code
+			resp.Groups = append(resp.Groups, fetched)
+		}
+		return resp, nil, true
+	})
+	_, err := source.ConsumerLag(context.Background(), "local", "concept-reader")
+	assert.True(t, errors.Is(err, kerr.GroupAuthorizationFailed), "%v", err)
+	assert.False(t, isTransient(err))
+	assert.True(t, strings.HasPrefix(err.Error(), "Could not fetch the offsets of consumer group concept-reader: "), err.Error())
+
+	_, err = source.ConsumerLag(context.Background(), "local", "concept-reader")
+	assert.NoError(t, err)
+
+	// a request left unanswered
+	cluster.ControlKey(int16(kmsg.ListGroups), func(kmsg.Request) (kmsg.Response, error, bool) {
+		return nil, nil, true
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = source.Consumers(ctx, "local")
+	assert.True(t, isTimeout(err), "%v", err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestKafkaLagSourceUnreachable(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	assert.NoError(t, err)
+	addrs := cluster.ListenAddrs()
+	cluster.Close()
+	admin, err := newKafkaAdmin(addrs, time.Second)
+	assert.NoError(t, err)
+	defer admin.Close()
+
+	_, err = newKafkaLagSource("local", admin).Consumers(context.Background(), "local")
+	assert.Error(t, err)
+	assert.True(t, isTransient(err), "%v", err)
+}
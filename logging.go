@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+)
+
+// logger writes the logs of the service as JSON lines, see initLogs.
+var logger = newLogger(os.Stdout, slog.LevelInfo)
+
+// repeatedWarnings throttles the warnings that would otherwise be logged on every poll, see warnRepeated.
+var repeatedWarnings = newLogThrottle(0)
+
+// initLogs makes the service log the messages of the given level and above to out, and log the same repeated warning
+// at most once per repeatInterval. A zero repeatInterval logs every warning.
+func initLogs(out io.Writer, level slog.Level, repeatInterval time.Duration) {
+	logger = newLogger(out, level)
+	repeatedWarnings = newLogThrottle(repeatInterval)
+}
+
+// newLogger creates a logger writing JSON lines with the time in UTC and the durations in seconds.
+// The fields about consumer groups and requests are named consistently: cluster, consumer_group, topic, lag,
+// burrow_url, duration and error.
+func newLogger(out io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			switch attr.Value.Kind() {
+			case slog.KindTime:
+				attr.Value = slog.TimeValue(attr.Value.Time().UTC())
+			case slog.KindDuration:
+				attr.Value = slog.Float64Value(attr.Value.Duration().Seconds())
+			}
+			return attr
+		},
+	}))
+}
+
+// parseLogLevel parses the level given as option: debug, info, warn or error.
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, errors.New("Invalid log level " + name + ", it must be debug, info, warn or error")
+	}
+	return level, nil
+}
+
+// errorArgs returns the arguments logging an error, along with the URL of the request to Burrow that failed if known.
+func errorArgs(err error) []interface{} {
+	args := []interface{}{"error", err}
+	var burrowErr *burrow.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &burrowErr):
+		args = append(args, "burrow_url", burrowErr.URL)
+	case errors.As(err, &urlErr):
+		args = append(args, "burrow_url", urlErr.URL)
+	}
+	return args
+}
+
+// repeatKey identifies a repeated warning by what it is about, e.g. repeatKey("lagging", cluster, consumerGroup).
+func repeatKey(parts ...string) string {
+	return strings.Join(parts, "/")
+}
+
+// warnRepeated logs a warning, unless the warning with the same key was logged less than the repeat interval ago.
+// The number of warnings suppressed since the last one logged is added as the suppressed field.
+func warnRepeated(key string, msg string, args ...interface{}) {
+	suppressed, ok := repeatedWarnings.allow(key, time.Now())
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	logger.Warn(msg, args...)
+}
+
+// clearRepeated forgets the warnings with the given keys, as what they were about is resolved: the next one is logged
+// right away.
+func clearRepeated(keys ...string) {
+	repeatedWarnings.clear(keys...)
+}
+
+// logThrottle deduplicates the warnings about the same thing, e.g. a consumer group lagging for hours, so that only
+// one of them is logged per interval.
+type logThrottle struct {
+	interval time.Duration
+
+	mu       sync.Mutex
+	warnings map[string]*throttledWarning
+	prunedAt time.Time
+}
+
+type throttledWarning struct {
+	loggedAt   time.Time
+	suppressed int
+}
+
+func newLogThrottle(interval time.Duration) *logThrottle {
+	return &logThrottle{interval: interval, warnings: map[string]*throttledWarning{}}
+}
+
+// allow tells whether the warning with the key is logged, and if so how many were suppressed since the last one.
+func (t *logThrottle) allow(key string, now time.Time) (int, bool) {
+	if t.interval <= 0 {
+		return 0, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	warning := t.warnings[key]
+	if warning == nil {
+		t.warnings[key] = &throttledWarning{loggedAt: now}
+		return 0, true
+	}
+	if now.Sub(warning.loggedAt) < t.interval {
+		warning.suppressed++
+		return 0, false
+	}
+	suppressed := warning.suppressed
+	*warning = throttledWarning{loggedAt: now}
+	return suppressed, true
+}
+
+func (t *logThrottle) clear(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		delete(t.warnings, key)
+	}
+}
+
+// prune forgets the warnings not logged for two intervals, e.g. about consumer groups that were deleted.
+func (t *logThrottle) prune(now time.Time) {
+	if now.Sub(t.prunedAt) < t.interval {
+		return
+	}
+	t.prunedAt = now
+	for key, warning := range t.warnings {
+		if now.Sub(warning.loggedAt) >= 2*t.interval {
+			delete(t.warnings, key)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+// logLines decodes the JSON lines logged.
+func logLines(t *testing.T, data []byte) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var fields map[string]interface{}
+		assert.NoError(t, json.Unmarshal(line, &fields), string(line))
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestLoggerFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLogger(&buf, slog.LevelInfo)
+	log.Debug("Not logged")
+	log.Info("Requested Burrow", "duration", 1500*time.Millisecond, "ends_at", time.Date(2026, 10, 16, 14, 0, 0, 0, time.FixedZone("CEST", 2*3600)))
+
+	lines := logLines(t, buf.Bytes())
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, "INFO", lines[0]["level"])
+		assert.Equal(t, "Requested Burrow", lines[0]["msg"])
+		assert.Equal(t, 1.5, lines[0]["duration"], "in seconds")
+		assert.Equal(t, "2026-10-16T12:00:00Z", lines[0]["ends_at"], "in UTC")
+		assert.Contains(t, lines[0]["time"], "Z")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, expected := range map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		level, err := parseLogLevel(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, level, name)
+	}
+	_, err := parseLogLevel("verbose")
+	assert.EqualError(t, err, "Invalid log level verbose, it must be debug, info, warn or error")
+}
+
+func TestErrorArgs(t *testing.T) {
+	burrowErr := &burrow.Error{URL: "http://burrow/v3/kafka/local/consumer", StatusCode: 500}
+	assert.Equal(t, []interface{}{"error", error(burrowErr), "burrow_url", burrowErr.URL}, errorArgs(burrowErr))
+
+	wrapped := fmt.Errorf("Could not list consumer groups: %w", burrowErr)
+	assert.Equal(t, []interface{}{"error", wrapped, "burrow_url", burrowErr.URL}, errorArgs(wrapped))
+
+	other := errors.New("No Kafka broker configured")
+	assert.Equal(t, []interface{}{"error", other}, errorArgs(other))
+}
+
+func TestLogThrottle(t *testing.T) {
+	throttle := newLogThrottle(time.Minute)
+	start := time.Now()
+
+	suppressed, ok := throttle.allow("lagging/local/content-notifications", start)
+	assert.True(t, ok)
+	assert.Equal(t, 0, suppressed)
+	for i := 1; i <= 3; i++ {
+		_, ok = throttle.allow("lagging/local/content-notifications", start.Add(time.Duration(i)*10*time.Second))
+		assert.False(t, ok, "repeated within the interval")
+	}
+	_, ok = throttle.allow("lagging/local/concept-reader", start.Add(10*time.Second))
+	assert.True(t, ok, "another warning")
+
+	suppressed, ok = throttle.allow("lagging/local/content-notifications", start.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 3, suppressed)
+
+	throttle.clear("lagging/local/content-notifications")
+	_, ok = throttle.allow("lagging/local/content-notifications", start.Add(61*time.Second))
+	assert.True(t, ok, "logged right away once cleared")
+
+	throttle.allow("lagging/local/content-notifications", start.Add(5*time.Minute))
+	assert.Len(t, throttle.warnings, 1, "old warnings pruned")
+}
+
+func TestWarnRepeated(t *testing.T) {
+	var buf bytes.Buffer
+	initLogs(&buf, slog.LevelInfo, time.Hour)
+	defer initLogs(&bytes.Buffer{}, slog.LevelInfo, 0)
+
+	for i := 0; i < 3; i++ {
+		warnRepeated(repeatKey("lagging", "local", "content-notifications"), "Consumer group is lagging", "consumer_group", "content-notifications", "lag", 20+i)
+	}
+	clearRepeated(repeatKey("lagging", "local", "content-notifications"))
+	warnRepeated(repeatKey("lagging", "local", "content-notifications"), "Consumer group is lagging", "consumer_group", "content-notifications", "lag", 30)
+
+	lines := logLines(t, buf.Bytes())
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "WARN", lines[0]["level"])
+		assert.Equal(t, 20.0, lines[0]["lag"])
+		assert.Equal(t, 30.0, lines[1]["lag"])
+	}
+
+	initLogs(&buf, slog.LevelInfo, 0)
+	buf.Reset()
+	for i := 0; i < 3; i++ {
+		warnRepeated(repeatKey("lagging", "local", "content-notifications"), "Consumer group is lagging")
+	}
+	assert.Len(t, logLines(t, buf.Bytes()), 3, "not throttled")
+}
@@ -1,24 +1,23 @@
 package main
 
 import (
-	"io"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/jawher/mow.cli"
 )
 
-const logPattern = log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile | log.LUTC
-
-var infoLogger *log.Logger
-var warnLogger *log.Logger
-var errorLogger *log.Logger
-
 func main() {
 	app := cli.App("aggregate-healthcheck", "Monitoring health of multiple services in cluster.")
 	port := app.String(cli.StringOpt{
@@ -33,61 +32,441 @@ func main() {
 		Desc:   "Base URL at which Burrow is reachable (e.g. http://ip-172-24-91-192.eu-west-1.compute.internal:8080/__burrow)",
 		EnvVar: "BURROW_URL",
 	})
+	burrowClusters := app.Strings(cli.StringsOpt{
+		Name:   "burrow-clusters",
+		Value:  []string{},
+		Desc:   "Comma-separated list of the Burrow clusters to check for lags. All clusters monitored by Burrow are checked if empty. (e.g. local,remote)",
+		EnvVar: "BURROW_CLUSTERS",
+	})
+	lagSourceName := app.String(cli.StringOpt{
+		Name:   "lag-source",
+		Value:  lagSourceBurrow,
+		Desc:   "Where the consumer group lags come from: burrow, or kafka to compute them from the committed and log end offsets read from the kafka-brokers.",
+		EnvVar: "LAG_SOURCE",
+	})
+	kafkaBrokers := app.Strings(cli.StringsOpt{
+		Name:   "kafka-brokers",
+		Value:  []string{},
+		Desc:   "Comma-separated list of Kafka brokers to read the offsets from when the lag-source is kafka. (e.g. kafka-1:9092,kafka-2:9092)",
+		EnvVar: "KAFKA_BROKERS",
+	})
+	kafkaCluster := app.String(cli.StringOpt{
+		Name:   "kafka-cluster",
+		Value:  "local",
+		Desc:   "Name under which the cluster of the kafka-brokers is reported when the lag-source is kafka.",
+		EnvVar: "KAFKA_CLUSTER",
+	})
 	whitelistedTopics := app.Strings(cli.StringsOpt{
 		Name:   "whitelisted-topics",
 		Value:  []string{},
-		Desc:   "Comma-separated list of kafka topics that we do not need to check for lags. (e.g. Concept,AnotherQ)",
+		Desc:   "Comma-separated list of kafka topics that we do not need to check for lags, as names, globs or regular expressions between slashes. (e.g. Concept,AnotherQ,Native*)",
 		EnvVar: "WHITELISTED_TOPICS",
 	})
 	whitelistedEnvironments := app.Strings(cli.StringsOpt{
 		Name:   "whitelisted-environments",
 		Value:  []string{},
-		Desc:   "Comma-separated list of environments that contain kafka bridges that we need to check for lags. (e.g. prod-uk, prod-us)",
+		Desc:   "Comma-separated list of environments that contain kafka bridges that we need to check for lags, as name prefixes, globs or regular expressions between slashes. (e.g. prod-uk, prod-us)",
 		EnvVar: "WHITELISTED_ENVS",
 	})
+	deniedConsumerGroups := app.Strings(cli.StringsOpt{
+		Name:   "denied-consumer-groups",
+		Value:  []string{},
+		Desc:   "Comma-separated list of consumer groups that are never checked for lags. (e.g. console-consumer-2324)",
+		EnvVar: "DENIED_CONSUMER_GROUPS",
+	})
 	maxLagTolerance := app.Int(cli.IntOpt{
 		Name:   "max-lag-tolerance",
 		Value:  1000,
-		Desc:   "Number of messages that can pile up before warning when Burrow reports no ERR.",
+		Desc:   "Number of messages that can pile up before warning when Burrow reports no ERR. A negative value disables the check.",
 		EnvVar: "MAX_LAG_TOLERANCE",
 	})
 	errLagTolerance := app.Int(cli.IntOpt{
 		Name:   "err-lag-tolerance",
 		Value:  30,
-		Desc:   "Number of messages that can pile up before warning when Burrow reports there is an ERR.",
+		Desc:   "Number of messages that can pile up before warning when Burrow reports there is an ERR. A negative value disables the check.",
 		EnvVar: "ERR_LAG_TOLERANCE",
 	})
+	maxTimeLag := app.Int(cli.IntOpt{
+		Name:   "max-time-lag",
+		Value:  0,
+		Desc:   "Number of seconds a consumer group can be behind on any partition, estimated from the Burrow offset timestamps. 0 disables the check.",
+		EnvVar: "MAX_TIME_LAG",
+	})
+	configFile := app.String(cli.StringOpt{
+		Name:   "config-file",
+		Value:  "",
+		Desc:   "Path to a YAML or JSON file defining which consumer groups and topics are checked and their lag thresholds and the metadata of their checks. The options are used as defaults.",
+		EnvVar: "CONFIG_FILE",
+	})
+	burrowTimeout := app.Int(cli.IntOpt{
+		Name:   "burrow-timeout",
+		Value:  5,
+		Desc:   "Number of seconds after which a request to Burrow, or Kafka if it is the lag source, is abandoned.",
+		EnvVar: "BURROW_TIMEOUT",
+	})
+	burrowConcurrency := app.Int(cli.IntOpt{
+		Name:   "burrow-concurrency",
+		Value:  10,
+		Desc:   "Maximum number of requests made to Burrow, or Kafka if it is the lag source, at the same time.",
+		EnvVar: "BURROW_CONCURRENCY",
+	})
+	burrowRetries := app.Int(cli.IntOpt{
+		Name:   "burrow-retries",
+		Value:  2,
+		Desc:   "Number of times a request to Burrow, or Kafka if it is the lag source, is made again after a transient failure.",
+		EnvVar: "BURROW_RETRIES",
+	})
+	burrowRetryBackoff := app.Int(cli.IntOpt{
+		Name:   "burrow-retry-backoff",
+		Value:  200,
+		Desc:   "Number of milliseconds to wait before the first retry of a request to Burrow, doubled on every retry and jittered.",
+		EnvVar: "BURROW_RETRY_BACKOFF",
+	})
+	circuitBreakerThreshold := app.Int(cli.IntOpt{
+		Name:   "circuit-breaker-threshold",
+		Value:  10,
+		Desc:   "Number of consecutive failed requests to Burrow after which no more are made until the cool down is over. 0 disables the circuit breaker.",
+		EnvVar: "CIRCUIT_BREAKER_THRESHOLD",
+	})
+	circuitBreakerCooldown := app.Int(cli.IntOpt{
+		Name:   "circuit-breaker-cooldown",
+		Value:  30,
+		Desc:   "Number of seconds after which a request is made to Burrow again once the circuit breaker opened.",
+		EnvVar: "CIRCUIT_BREAKER_COOLDOWN",
+	})
+	pollInterval := app.Int(cli.IntOpt{
+		Name:   "poll-interval",
+		Value:  30,
+		Desc:   "Number of seconds between two consecutive fetches of all consumer group lags from Burrow.",
+		EnvVar: "POLL_INTERVAL",
+	})
+	maxSnapshotAge := app.Int(cli.IntOpt{
+		Name:   "max-snapshot-age",
+		Value:  120,
+		Desc:   "Number of seconds after which the last fetched consumer group lags are considered stale and the healthcheck fails. 0 disables the staleness check.",
+		EnvVar: "MAX_SNAPSHOT_AGE",
+	})
+
+	lagTrendWindow := app.Int(cli.IntOpt{
+		Name:   "lag-trend-window",
+		Value:  300,
+		Desc:   "Number of seconds of lag history used to tell whether a lag is draining, stable or growing. The message tolerances don't apply to lags draining within max-drain-time. 0 disables the trend detection.",
+		EnvVar: "LAG_TREND_WINDOW",
+	})
+	maxDrainTime := app.Int(cli.IntOpt{
+		Name:   "max-drain-time",
+		Value:  1800,
+		Desc:   "Number of seconds within which a draining lag has to be estimated to be consumed for the message tolerances not to apply.",
+		EnvVar: "MAX_DRAIN_TIME",
+	})
+
+	failAfter := app.Int(cli.IntOpt{
+		Name:   "fail-after",
+		Value:  1,
+		Desc:   "Number of consecutive failing evaluations after which a consumer group is reported unhealthy.",
+		EnvVar: "FAIL_AFTER",
+	})
+	failAfterDuration := app.Int(cli.IntOpt{
+		Name:   "fail-after-duration",
+		Value:  0,
+		Desc:   "Number of seconds a consumer group has to keep failing before it is reported unhealthy, in addition to fail-after.",
+		EnvVar: "FAIL_AFTER_DURATION",
+	})
+	recoverAfter := app.Int(cli.IntOpt{
+		Name:   "recover-after",
+		Value:  1,
+		Desc:   "Number of consecutive passing evaluations after which an unhealthy consumer group is reported healthy again.",
+		EnvVar: "RECOVER_AFTER",
+	})
+	silencesFile := app.String(cli.StringOpt{
+		Name:   "silences-file",
+		Value:  "",
+		Desc:   "Path to the JSON file in which the silences are saved so that they survive restarts. They are kept in memory only if empty.",
+		EnvVar: "SILENCES_FILE",
+	})
+	adminApiKey := app.String(cli.StringOpt{
+		Name:   "admin-api-key",
+		Value:  "",
+		Desc:   "API key required by the admin API managing the silences. The admin API is disabled if empty.",
+		EnvVar: "ADMIN_API_KEY",
+	})
+
+	alertmanagerUrls := app.Strings(cli.StringsOpt{
+		Name:   "alertmanager-urls",
+		Value:  []string{},
+		Desc:   "Comma-separated list of the base URLs of the Alertmanager instances to push the alerts to (e.g. http://alertmanager:9093). No alerts are pushed if empty.",
+		EnvVar: "ALERTMANAGER_URLS",
+	})
+	alertmanagerResendInterval := app.Int(cli.IntOpt{
+		Name:   "alertmanager-resend-interval",
+		Value:  60,
+		Desc:   "Number of seconds after which the firing alerts are pushed to Alertmanager again.",
+		EnvVar: "ALERTMANAGER_RESEND_INTERVAL",
+	})
+
+	gtgPolicyName := app.String(cli.StringOpt{
+		Name:   "gtg-policy",
+		Value:  string(gtgAllGroups),
+		Desc:   "Which consumer groups have to be healthy for the service to be good to go: self (none, only Burrow has to be reachable), critical (the ones marked as critical in the config file) or all.",
+		EnvVar: "GTG_POLICY",
+	})
+
+	logLevelName := app.String(cli.StringOpt{
+		Name:   "log-level",
+		Value:  "info",
+		Desc:   "Minimum level of the messages logged: debug, info, warn or error.",
+		EnvVar: "LOG_LEVEL",
+	})
+	logRepeatInterval := app.Int(cli.IntOpt{
+		Name:   "log-repeat-interval",
+		Value:  300,
+		Desc:   "Number of seconds during which the same warning, e.g. about a lagging consumer group, is not logged again. 0 logs it on every poll.",
+		EnvVar: "LOG_REPEAT_INTERVAL",
+	})
+
+	tracingEndpoint := app.String(cli.StringOpt{
+		Name:   "tracing-endpoint",
+		Value:  "",
+		Desc:   "URL of the OTLP/HTTP endpoint the OpenTelemetry traces are exported to (e.g. http://otel-collector:4318). Tracing is disabled if empty.",
+		EnvVar: "TRACING_ENDPOINT",
+	})
+	tracingSamplePercent := app.Int(cli.IntOpt{
+		Name:   "tracing-sample-percent",
+		Value:  100,
+		Desc:   "Percentage of the traces started by the service that are exported. The traces continued from a caller are exported if the caller's are.",
+		EnvVar: "TRACING_SAMPLE_PERCENT",
+	})
+
+	// lagcheck is what both the service and the check command are made of.
+	type lagcheck struct {
+		healthcheck   *healthcheck
+		conf          *config
+		buildSettings func(conf *config) (*lagSettings, error)
+		silences      *silences
+	}
+
+	// setUp builds the healthcheck from the options and the config file, checking only the selected consumer groups
+	// if any are.
+	setUp := func(lagHysteresis *hysteresis, selectedConsumerGroups []pattern) (*lagcheck, error) {
+		var kafkaSource *kafkaLagSource
+		switch *lagSourceName {
+		case lagSourceBurrow:
+		case lagSourceKafka:
+			if len(*kafkaBrokers) == 0 {
+				return nil, errors.New("No Kafka brokers given, they are required to use kafka as lag source")
+			}
+			admin, err := newKafkaAdmin(*kafkaBrokers, time.Duration(*burrowTimeout)*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			kafkaSource = newKafkaLagSource(*kafkaCluster, admin)
+		default:
+			return nil, fmt.Errorf("Invalid lag source %q, it must be %s or %s", *lagSourceName, lagSourceBurrow, lagSourceKafka)
+		}
+
+		// buildSettings combines the options with the config file, whose burrowUrl overrides the option.
+		buildSettings := func(conf *config) (*lagSettings, error) {
+			lagFilters, err := newFilters(*whitelistedTopics, *whitelistedEnvironments, *deniedConsumerGroups, conf)
+			if err != nil {
+				return nil, err
+			}
+			var source lagSource = kafkaSource
+			if kafkaSource == nil {
+				url := *burrowUrl
+				if conf.BurrowURL != "" {
+					url = conf.BurrowURL
+				}
+				source = burrow.NewClient(url, burrow.WithTimeout(time.Duration(*burrowTimeout)*time.Second))
+			}
+			return &lagSettings{
+				source:     source,
+				filters:    lagFilters.selecting(selectedConsumerGroups),
+				thresholds: newThresholds(*maxLagTolerance, *errLagTolerance, time.Duration(*maxTimeLag)*time.Second, conf.Thresholds),
+				checks:     newCheckRules(conf.Checks),
+				webhooks:   conf.Webhooks,
+			}, nil
+		}
+
+		conf, err := loadConfig(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		settings, err := buildSettings(conf)
+		if err != nil {
+			return nil, err
+		}
+		lagSilences, err := newSilences(*silencesFile)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := parseGTGPolicy(*gtgPolicyName)
+		if err != nil {
+			return nil, err
+		}
+
+		healthCheck := newHealthcheck(healthcheckOptions{
+			source:         settings.source,
+			clusters:       *burrowClusters,
+			filters:        settings.filters,
+			thresholds:     settings.thresholds,
+			checks:         settings.checks,
+			pool:           newWorkerPool(*burrowConcurrency, time.Duration(*burrowTimeout)*time.Second),
+			retries:        newBackoff(*burrowRetries, time.Duration(*burrowRetryBackoff)*time.Millisecond),
+			breaker:        newCircuitBreaker(*circuitBreakerThreshold, time.Duration(*circuitBreakerCooldown)*time.Second),
+			hysteresis:     lagHysteresis,
+			silences:       lagSilences,
+			gtgPolicy:      policy,
+			pollInterval:   time.Duration(*pollInterval) * time.Second,
+			maxSnapshotAge: time.Duration(*maxSnapshotAge) * time.Second,
+			trendWindow:    time.Duration(*lagTrendWindow) * time.Second,
+			maxDrainTime:   time.Duration(*maxDrainTime) * time.Second,
+		})
+		return &lagcheck{healthcheck: healthCheck, conf: conf, buildSettings: buildSettings, silences: lagSilences}, nil
+	}
+
+	app.Command("check", "Check the consumer groups once, print their lags and exit with 0 if none is lagging, 1 if any is, and 2 if their lags couldn't all be retrieved or the options are invalid.", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--format] [CONSUMER_GROUPS...]"
+		format := cmd.String(cli.StringOpt{
+			Name:  "format",
+			Value: checkFormatTable,
+			Desc:  "Output format: table or json.",
+		})
+		consumerGroups := cmd.Strings(cli.StringsArg{
+			Name: "CONSUMER_GROUPS",
+			Desc: "Consumer groups to check, as names, globs or regular expressions between slashes. All are checked if none is given.",
+		})
+
+		cmd.Action = func() {
+			// stdout is left to the result
+			initLogs(os.Stderr, slog.LevelInfo, 0)
+			logLevel, err := parseLogLevel(*logLevelName)
+			if err != nil {
+				logger.Error(err.Error())
+				cli.Exit(checkFailed)
+			}
+			initLogs(os.Stderr, logLevel, 0)
+
+			if *format != checkFormatTable && *format != checkFormatJSON {
+				logger.Error(fmt.Sprintf("Invalid format %q, it must be %s or %s", *format, checkFormatTable, checkFormatJSON))
+				cli.Exit(checkFailed)
+			}
+			var selected []pattern
+			for _, raw := range *consumerGroups {
+				p, err := parsePattern(raw)
+				if err != nil {
+					logger.Error(fmt.Sprintf("Invalid consumer group: %v", err))
+					cli.Exit(checkFailed)
+				}
+				selected = append(selected, p)
+			}
+			// a single evaluation, so without flap suppression
+			lc, err := setUp(newHysteresis(1, 0, 1), selected)
+			if err != nil {
+				logger.Error(err.Error())
+				cli.Exit(checkFailed)
+			}
+			cli.Exit(runCheck(lc.healthcheck, os.Stdout, *format))
+		}
+	})
 
 	app.Action = func() {
-		initLogs(os.Stdout, os.Stdout, os.Stderr)
+		logLevel, err := parseLogLevel(*logLevelName)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		initLogs(os.Stdout, logLevel, time.Duration(*logRepeatInterval)*time.Second)
 
-		burrowAddress := *burrowUrl
-		if strings.HasSuffix(burrowAddress, "/") {
-			burrowAddress = burrowAddress[:len(burrowAddress)-1]
+		if *tracingEndpoint != "" {
+			if *tracingSamplePercent < 0 || *tracingSamplePercent > 100 {
+				logger.Error("The tracing sample percentage must be between 0 and 100")
+				os.Exit(1)
+			}
+			shutdownTracing, err := initTracing(context.Background(), *tracingEndpoint, *tracingSamplePercent)
+			if err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			// flush the spans left before stopping
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-stop
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := shutdownTracing(ctx); err != nil {
+					logger.Warn("Could not export the last spans", "error", err)
+				}
+				os.Exit(0)
+			}()
+			logger.Info("Exporting traces", "endpoint", *tracingEndpoint, "sample_percent", *tracingSamplePercent)
 		}
 
-		infoLogger.Printf("Non-monitored topics: %v", *whitelistedTopics)
+		if *pollInterval <= 0 {
+			logger.Error("The poll interval must be positive")
+			os.Exit(1)
+		}
+		logger.Info("Non-monitored topics", "topics", *whitelistedTopics)
+
+		lc, err := setUp(newHysteresis(*failAfter, time.Duration(*failAfterDuration)*time.Second, *recoverAfter), nil)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		healthCheck := lc.healthcheck
+		switch source := healthCheck.current().source.(type) {
+		case *burrow.Client:
+			logger.Info("Reading lags from Burrow", "burrow_url", source.BaseURL())
+		case *kafkaLagSource:
+			logger.Info("Computing lags from Kafka brokers", "cluster", *kafkaCluster, "brokers", *kafkaBrokers)
+		}
+
+		healthCheck.notify(newNotifier(&http.Client{Timeout: webhookTimeout}))
+		if len(*alertmanagerUrls) > 0 {
+			resendInterval := time.Duration(*alertmanagerResendInterval) * time.Second
+			if resendInterval <= 0 {
+				logger.Error("The Alertmanager resend interval must be positive")
+				os.Exit(1)
+			}
+			healthCheck.pushAlerts(newAlertPusher(*alertmanagerUrls, &http.Client{Timeout: alertmanagerTimeout}, resendInterval, time.Duration(*pollInterval)*time.Second))
+		}
+		healthCheck.poller.start()
+
+		if *configFile != "" {
+			reloader := newConfigReloader(*configFile, lc.conf, lc.buildSettings, healthCheck.update)
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			if err := reloader.start(hup); err != nil {
+				logger.Warn("Could not watch config file, it will be reloaded on SIGHUP only", "config_file", *configFile, "error", err)
+				go func() {
+					for range hup {
+						reloader.reload()
+					}
+				}()
+			}
+		}
 
-		healthCheck := newHealthcheck(burrowAddress, *whitelistedTopics, *whitelistedEnvironments, *maxLagTolerance, *errLagTolerance)
 		router := mux.NewRouter()
+		router.Use(traceRequests)
 		router.Path("/__health").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(healthCheck.Health())})
+		router.Path("/__lag").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(healthCheck.LagReport())})
+		router.Path("/metrics").Handler(handlers.MethodHandler{"GET": healthCheck.metrics.handler()})
+		if *adminApiKey != "" {
+			newAdminAPI(lc.silences, *adminApiKey).register(router)
+		} else {
+			logger.Info("No admin API key given, the admin API is disabled.")
+		}
 		router.Path(status.GTGPath).Handler(handlers.MethodHandler{"GET": http.HandlerFunc(status.NewGoodToGoHandler(healthCheck.GTG))})
 
-		infoLogger.Printf("Kafka Lagcheck listening on port %v ...", *port)
-		err := http.ListenAndServe(":"+*port, router)
+		logger.Info("Kafka Lagcheck listening", "port", *port)
+		err = http.ListenAndServe(":"+*port, router)
 		if err != nil {
-			errorLogger.Printf("Can't set up HTTP listener on %s. %v", *port, err)
+			logger.Error("Can't set up HTTP listener", "port", *port, "error", err)
 			os.Exit(1)
 		}
 	}
 	err := app.Run(os.Args)
 	if err != nil {
-		errorLogger.Printf("Running app unsuccessful: %v", err)
+		logger.Error("Running app unsuccessful", "error", err)
 	}
 }
-
-func initLogs(infoHandle io.Writer, warnHandle io.Writer, errorHandle io.Writer) {
-	infoLogger = log.New(infoHandle, "INFO  - ", logPattern)
-	warnLogger = log.New(warnHandle, "WARN  - ", logPattern)
-	errorLogger = log.New(errorHandle, "ERROR - ", logPattern)
-}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "kafka_lagcheck"
+
+var (
+	totalLagDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "consumer_group", "total_lag"),
+		"Total lag of the consumer group in number of messages, as reported by Burrow.",
+		[]string{"cluster", "consumer_group"}, nil,
+	)
+	groupStatusDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "consumer_group", "status"),
+		"Burrow status of the consumer group, always 1 with the status as label.",
+		[]string{"cluster", "consumer_group", "status"}, nil,
+	)
+	checkOkDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "consumer_group", "check_ok"),
+		"Outcome of the lag check of the consumer group as served on /__health, 1 if ok or silenced and 0 if lagging or unknown.",
+		[]string{"cluster", "consumer_group"}, nil,
+	)
+	partitionLagDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "partition", "lag"),
+		"Lag of a consumer group on a topic partition in number of messages, as reported by Burrow.",
+		[]string{"cluster", "consumer_group", "topic", "partition", "status"}, nil,
+	)
+	lagRateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "consumer_group", "lag_rate"),
+		"Rate at which the lag of the consumer group on the topics that are not whitelisted changes, in messages per second, with its trend as label.",
+		[]string{"cluster", "consumer_group", "trend"}, nil,
+	)
+	circuitStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "burrow", "circuit_breaker_state"),
+		"State of the circuit breaker of the requests to Burrow, 1 for the current state and 0 for the others.",
+		[]string{"state"}, nil,
+	)
+	snapshotAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "snapshot", "age_seconds"),
+		"Number of seconds since the consumer group lags were last fetched from Burrow.",
+		nil, nil,
+	)
+)
+
+// metrics exposes the consumer group lags of the latest snapshot together with the Burrow request statistics.
+type metrics struct {
+	registry              *prometheus.Registry
+	burrowRequestDuration *prometheus.HistogramVec
+	burrowRequestErrors   *prometheus.CounterVec
+	burrowRequestRetries  *prometheus.CounterVec
+}
+
+// newMetrics creates the metrics of the snapshots of the poller, with checkPasses telling whether the check of a
+// consumer group passes.
+func newMetrics(p *poller, breaker *circuitBreaker, checkPasses func(lag consumerGroupLag) bool) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		burrowRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "burrow",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the requests made to Burrow.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"request"}),
+		burrowRequestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "burrow",
+			Name:      "request_errors_total",
+			Help:      "Number of requests made to Burrow that failed or returned a non 200 status.",
+		}, []string{"request"}),
+		burrowRequestRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "burrow",
+			Name:      "request_retries_total",
+			Help:      "Number of requests made to Burrow again after a transient failure.",
+		}, []string{"request"}),
+	}
+	m.registry.MustRegister(
+		m.burrowRequestDuration,
+		m.burrowRequestErrors,
+		m.burrowRequestRetries,
+		&snapshotCollector{poller: p, checkPasses: checkPasses},
+		&breakerCollector{breaker: breaker},
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) observeBurrowRequest(request string, duration time.Duration, err error) {
+	m.burrowRequestDuration.WithLabelValues(request).Observe(duration.Seconds())
+	if err != nil {
+		m.burrowRequestErrors.WithLabelValues(request).Inc()
+	}
+}
+
+func (m *metrics) observeBurrowRetry(request string) {
+	m.burrowRequestRetries.WithLabelValues(request).Inc()
+}
+
+// breakerCollector exposes the current state of the circuit breaker.
+type breakerCollector struct {
+	breaker *circuitBreaker
+}
+
+func (c *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- circuitStateDesc
+}
+
+func (c *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	current := c.breaker.status().state
+	for _, state := range circuitStates {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(circuitStateDesc, prometheus.GaugeValue, value, state.String())
+	}
+}
+
+// snapshotCollector derives the lag metrics from the latest snapshot on every scrape,
+// so they always match what the healthcheck reports.
+type snapshotCollector struct {
+	poller      *poller
+	checkPasses func(lag consumerGroupLag) bool
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- totalLagDesc
+	ch <- groupStatusDesc
+	ch <- checkOkDesc
+	ch <- partitionLagDesc
+	ch <- lagRateDesc
+	ch <- snapshotAgeDesc
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	snapshot := c.poller.current()
+	if snapshot == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue, snapshot.age().Seconds())
+
+	for _, cluster := range snapshot.clusters {
+		for _, lag := range cluster.consumerGroups {
+			c.collectConsumerGroup(ch, lag)
+		}
+	}
+}
+
+func (c *snapshotCollector) collectConsumerGroup(ch chan<- prometheus.Metric, lag consumerGroupLag) {
+	checkOk := 0.0
+	if c.checkPasses(lag) {
+		checkOk = 1
+	}
+	ch <- prometheus.MustNewConstMetric(checkOkDesc, prometheus.GaugeValue, checkOk, lag.cluster, lag.consumerGroup)
+
+	if lag.status == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(totalLagDesc, prometheus.GaugeValue, float64(lag.status.TotalLag), lag.cluster, lag.consumerGroup)
+	ch <- prometheus.MustNewConstMetric(groupStatusDesc, prometheus.GaugeValue, 1, lag.cluster, lag.consumerGroup, string(lag.status.Status))
+	if lag.trends.group.direction != trendUnknown {
+		ch <- prometheus.MustNewConstMetric(lagRateDesc, prometheus.GaugeValue, lag.trends.group.rate, lag.cluster, lag.consumerGroup, lag.trends.group.direction.String())
+	}
+	for _, partition := range lag.status.Partitions {
+		ch <- prometheus.MustNewConstMetric(partitionLagDesc, prometheus.GaugeValue, float64(partition.Lag()),
+			lag.cluster, lag.consumerGroup, partition.Topic, strconv.Itoa(int(partition.Partition)), string(partition.Status))
+	}
+}
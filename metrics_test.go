@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestMetrics(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+
+	consumers := map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"consumer1", "consumer2", "consumer3"},
+	}
+	consumersResponse, _ := httpmock.NewJsonResponder(200, consumers)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+
+	lagging := map[string]interface{}{
+		"error":   false,
+		"message": "consumer group status returned",
+		"status": map[string]interface{}{
+			"status":   "WARN",
+			"complete": 1.0,
+			"partitions": []map[string]interface{}{
+				{"topic": "CmsPublicationEvents", "partition": 0, "status": "WARN", "current_lag": 40, "end": map[string]interface{}{"lag": 35}},
+				{"topic": "CmsPublicationEvents", "partition": 1, "status": "STALL", "end": map[string]interface{}{"lag": 7}},
+			},
+			"partition_count": 2,
+			"maxlag":          nil,
+			"totallag":        47,
+		},
+	}
+	laggingResponse, _ := httpmock.NewJsonResponder(200, lagging)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer1/lag", laggingResponse)
+
+	ok := map[string]interface{}{
+		"error":   false,
+		"message": "consumer group status returned",
+		"status": map[string]interface{}{
+			"status":          "OK",
+			"complete":        1.0,
+			"partitions":      []map[string]interface{}{},
+			"partition_count": 1,
+			"maxlag":          nil,
+			"totallag":        0,
+		},
+	}
+	okResponse, _ := httpmock.NewJsonResponder(200, ok)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer2/lag", okResponse)
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer3/lag", httpmock.NewStringResponder(500, ""))
+
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), retries: newBackoff(1, time.Millisecond), breaker: newCircuitBreaker(10, time.Minute)})
+	h.poller.poll()
+
+	w := httptest.NewRecorder()
+	h.metrics.handler().ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	expectedLines := []string{
+		`kafka_lagcheck_consumer_group_total_lag{cluster="local",consumer_group="consumer1"} 47`,
+		`kafka_lagcheck_consumer_group_total_lag{cluster="local",consumer_group="consumer2"} 0`,
+		`kafka_lagcheck_consumer_group_status{cluster="local",consumer_group="consumer1",status="WARN"} 1`,
+		`kafka_lagcheck_consumer_group_status{cluster="local",consumer_group="consumer2",status="OK"} 1`,
+		`kafka_lagcheck_consumer_group_check_ok{cluster="local",consumer_group="consumer1"} 0`,
+		`kafka_lagcheck_consumer_group_check_ok{cluster="local",consumer_group="consumer2"} 1`,
+		`kafka_lagcheck_consumer_group_check_ok{cluster="local",consumer_group="consumer3"} 0`,
+		`kafka_lagcheck_partition_lag{cluster="local",consumer_group="consumer1",partition="0",status="WARN",topic="CmsPublicationEvents"} 40`,
+		`kafka_lagcheck_partition_lag{cluster="local",consumer_group="consumer1",partition="1",status="STALL",topic="CmsPublicationEvents"} 7`,
+		`kafka_lagcheck_burrow_request_duration_seconds_count{request="consumer_list"} 1`,
+		`kafka_lagcheck_burrow_request_duration_seconds_count{request="consumer_status"} 4`,
+		`kafka_lagcheck_burrow_request_errors_total{request="consumer_status"} 2`,
+		`kafka_lagcheck_burrow_request_retries_total{request="consumer_status"} 1`,
+		`kafka_lagcheck_burrow_circuit_breaker_state{state="closed"} 1`,
+		`kafka_lagcheck_burrow_circuit_breaker_state{state="open"} 0`,
+	}
+	for _, line := range expectedLines {
+		assert.Contains(t, body, line)
+	}
+	assert.NotContains(t, body, `kafka_lagcheck_consumer_group_total_lag{cluster="local",consumer_group="consumer3"}`)
+
+	// silenced, the lagging consumer group is ok like on /__health
+	h.silences, _ = newSilences("")
+	_, err := h.silences.add(silence{ConsumerGroup: mustParsePattern("consumer1"), Reason: "reindexing", EndsAt: time.Now().Add(time.Hour)}, time.Now())
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.metrics.handler().ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+	assert.Contains(t, w.Body.String(), `kafka_lagcheck_consumer_group_check_ok{cluster="local",consumer_group="consumer1"} 1`)
+}
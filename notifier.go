@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Events notified to the webhooks.
+const (
+	eventLagging           = "lagging"
+	eventRecovered         = "recovered"
+	eventBurrowUnavailable = "burrow_unavailable"
+	eventBurrowAvailable   = "burrow_available"
+)
+
+var notificationEvents = []string{eventLagging, eventRecovered, eventBurrowUnavailable, eventBurrowAvailable}
+
+func isNotificationEvent(event string) bool {
+	for _, known := range notificationEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats of the payloads posted to the webhooks.
+const (
+	webhookGeneric = "generic"
+	webhookSlack   = "slack"
+)
+
+// webhookTimeout is the time limit to post a notification to a webhook.
+const webhookTimeout = 10 * time.Second
+
+const defaultSlackText = "{{if .Failing}}:red_circle:{{else}}:white_check_mark:{{end}} {{.Text}}"
+
+var defaultSlackTemplate = template.Must(template.New("template").Parse(defaultSlackText))
+
+// webhook is a webhook of the config file notified when the checks change state.
+type webhook struct {
+	URL    *webhookURL `yaml:"url" json:"url"`
+	Format string      `yaml:"format" json:"format,omitempty"`
+	// Template renders the whole body of a generic webhook, and the text of a Slack one.
+	Template *payloadTemplate `yaml:"template" json:"template,omitempty"`
+	// Events are the events notified to the webhook, all of them if empty.
+	Events []string `yaml:"events" json:"events,omitempty"`
+	// RenotifyInterval is how often a check still failing is notified again, never if unset.
+	RenotifyInterval *duration `yaml:"renotifyInterval" json:"renotifyInterval,omitempty"`
+}
+
+func (w *webhook) accepts(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, accepted := range w.Events {
+		if accepted == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *webhook) renotifyInterval() time.Duration {
+	if w.RenotifyInterval == nil {
+		return 0
+	}
+	return time.Duration(*w.RenotifyInterval)
+}
+
+// payload renders the body posted to the webhook for the notification.
+func (w *webhook) payload(n notification) ([]byte, error) {
+	if w.Format == webhookSlack {
+		tmpl := defaultSlackTemplate
+		if w.Template != nil {
+			tmpl = w.Template.template
+		}
+		var text bytes.Buffer
+		if err := tmpl.Execute(&text, n); err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]string{"text": text.String()})
+	}
+	if w.Template == nil {
+		return json.Marshal(n)
+	}
+	var body bytes.Buffer
+	if err := w.Template.template.Execute(&body, n); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// webhookURL is the URL of a webhook. As it usually embeds a secret, it is logged as its host and a hash of the rest.
+type webhookURL struct {
+	raw    string
+	parsed *url.URL
+}
+
+func (u *webhookURL) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("invalid webhook url, it must be an absolute http or https URL")
+	}
+	*u = webhookURL{raw: raw, parsed: parsed}
+	return nil
+}
+
+func (u webhookURL) String() string {
+	sum := sha256.Sum256([]byte(u.raw))
+	return fmt.Sprintf("%s://%s (%x)", u.parsed.Scheme, u.parsed.Host, sum[:4])
+}
+
+func (u webhookURL) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// payloadTemplate is a text/template rendering the payload of a webhook from a notification. It can use the json
+// function to quote a value in a JSON body, e.g. {"summary": {{json .Text}}}.
+type payloadTemplate struct {
+	raw      string
+	template *template.Template
+}
+
+var payloadFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+func (t *payloadTemplate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	parsed, err := template.New("template").Funcs(payloadFuncs).Option("missingkey=error").Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook template: %v", err)
+	}
+	if err := parsed.Execute(&bytes.Buffer{}, notification{}); err != nil {
+		return fmt.Errorf("invalid webhook template: %v", err)
+	}
+	*t = payloadTemplate{raw: raw, template: parsed}
+	return nil
+}
+
+func (t payloadTemplate) MarshalText() ([]byte, error) {
+	return []byte(t.raw), nil
+}
+
+// notification is the body posted to a generic webhook, and what the templates can refer to.
+type notification struct {
+	Event string `json:"event"`
+	// Renotification is set when a check still failing is notified again.
+	Renotification   bool      `json:"renotification"`
+	Failing          bool      `json:"failing"`
+	Text             string    `json:"text"`
+	CheckID          string    `json:"checkId"`
+	Name             string    `json:"name"`
+	Cluster          string    `json:"cluster,omitempty"`
+	ConsumerGroup    string    `json:"consumerGroup,omitempty"`
+	Topic            string    `json:"topic,omitempty"`
+	Team             string    `json:"team,omitempty"`
+	Severity         uint8     `json:"severity"`
+	Message          string    `json:"message"`
+	TechnicalSummary string    `json:"technicalSummary"`
+	PanicGuide       string    `json:"panicGuide"`
+	BusinessImpact   string    `json:"businessImpact"`
+	Since            time.Time `json:"since"`
+	Time             time.Time `json:"time"`
+}
+
+func newNotification(event string, state checkState, since time.Time, now time.Time) notification {
+	n := notification{
+		Event:            event,
+		Failing:          event == eventLagging || event == eventBurrowUnavailable,
+		CheckID:          state.id,
+		Name:             state.name,
+		Cluster:          state.cluster,
+		ConsumerGroup:    state.consumerGroup,
+		Topic:            state.topic,
+		Team:             state.team,
+		Severity:         state.severity,
+		Message:          state.message,
+		TechnicalSummary: state.technicalSummary,
+		PanicGuide:       state.panicGuide,
+		BusinessImpact:   state.businessImpact,
+		Since:            since.UTC(),
+		Time:             now.UTC(),
+	}
+	switch event {
+	case eventLagging:
+		n.Text = fmt.Sprintf("Cluster %s: %s", state.cluster, state.message)
+	case eventRecovered:
+		n.Text = fmt.Sprintf("Consumer group %s on cluster %s recovered after %v.", state.consumerGroup, state.cluster, now.Sub(since).Round(time.Second))
+	case eventBurrowUnavailable:
+		n.Text = state.message
+	case eventBurrowAvailable:
+		n.Text = fmt.Sprintf("Burrow is available again after %v.", now.Sub(since).Round(time.Second))
+		if state.cluster != "" {
+			n.Text = fmt.Sprintf("Consumer groups of cluster %s can be listed again after %v.", state.cluster, now.Sub(since).Round(time.Second))
+		}
+	}
+	return n
+}
+
+// renotification notifies again a check that is still failing.
+func (n notification) renotification(now time.Time) notification {
+	n.Renotification = true
+	n.Time = now.UTC()
+	n.Text = fmt.Sprintf("Still failing since %s. %s", n.Since.Format(time.RFC3339), n.Text)
+	return n
+}
+
+// notifiedCheck is the notification sent when a check started failing, with when it was last notified to every
+// webhook, identified by its URL.
+type notifiedCheck struct {
+	notification notification
+	notified     map[string]time.Time
+}
+
+// notifier posts to the webhooks when a consumer group starts lagging or recovers, and when Burrow becomes unavailable
+// or available again, as tracked by a checkTracker. A check failing on every poll is notified once, and again every
+// renotify interval of the webhook if it has one.
+type notifier struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	tracker  *checkTracker
+	notified map[string]*notifiedCheck
+
+	deliveries orderedDeliveries
+}
+
+func newNotifier(client *http.Client) *notifier {
+	return &notifier{client: client, tracker: newCheckTracker(), notified: map[string]*notifiedCheck{}}
+}
+
+// notify makes the notifier observe the evaluation of every new snapshot, posting to the webhooks of the settings in use.
+func (h *healthcheck) notify(n *notifier) {
+	h.poller.onSnapshot(func(snapshot *lagSnapshot) {
+		n.observe(h.evaluate(snapshot), h.current().webhooks)
+	})
+}
+
+// observe compares the evaluation with the previous ones and posts the resulting notifications to the webhooks.
+func (n *notifier) observe(e *evaluation, webhooks []*webhook) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var transitions []transition
+	for _, changed := range n.tracker.observe(e) {
+		if !changed.state.failing {
+			delete(n.notified, changed.key)
+			transitions = append(transitions, transition{key: changed.key, notification: recovery(changed.state, changed.since, e.at)})
+			continue
+		}
+		event := eventLagging
+		if changed.state.kind == burrowCheck {
+			event = eventBurrowUnavailable
+		}
+		notification := newNotification(event, changed.state, changed.since, e.at)
+		notified := &notifiedCheck{notification: notification, notified: map[string]time.Time{}}
+		for _, hook := range webhooks {
+			notified.notified[hook.URL.raw] = e.at
+		}
+		n.notified[changed.key] = notified
+		transitions = append(transitions, transition{key: changed.key, notification: notification})
+	}
+
+	for _, hook := range webhooks {
+		batch := n.renotifications(hook, e.at)
+		for _, transition := range transitions {
+			if hook.accepts(transition.notification.Event) {
+				batch = append(batch, transition.notification)
+			}
+		}
+		if len(batch) > 0 {
+			n.deliver(hook, batch)
+		}
+	}
+}
+
+// transition is the notification of a check that changed state.
+type transition struct {
+	key          string
+	notification notification
+}
+
+// recovery notifies that a check passes again, or isn't there anymore.
+func recovery(state checkState, since time.Time, now time.Time) notification {
+	event := eventRecovered
+	if state.kind == burrowCheck {
+		event = eventBurrowAvailable
+	}
+	return newNotification(event, state, since, now)
+}
+
+// renotifications notifies again the checks still failing that were last notified to the webhook more than its
+// renotify interval ago, and notifies a webhook added since a check started failing of it right away.
+func (n *notifier) renotifications(hook *webhook, now time.Time) []notification {
+	interval := hook.renotifyInterval()
+	var renotifications []notification
+	for _, key := range n.tracker.failingKeys() {
+		notified := n.notified[key]
+		if !hook.accepts(notified.notification.Event) {
+			continue
+		}
+		last, ok := notified.notified[hook.URL.raw]
+		if ok && (interval <= 0 || now.Sub(last) < interval) {
+			continue
+		}
+		notified.notified[hook.URL.raw] = now
+		renotification := newNotification(notified.notification.Event, n.tracker.failing[key].state, notified.notification.Since, now)
+		renotifications = append(renotifications, renotification.renotification(now))
+	}
+	return renotifications
+}
+
+// deliver posts the notifications in the background, after the ones previously delivered to the same webhook.
+func (n *notifier) deliver(hook *webhook, batch []notification) {
+	n.deliveries.start(hook.URL.raw, func() {
+		for _, notification := range batch {
+			if err := n.post(hook, notification); err != nil {
+				logger.Warn("Could not notify webhook", "webhook", hook.URL.String(), "event", notification.Event, "check", notification.CheckID, "error", err)
+			}
+		}
+	})
+}
+
+func (n *notifier) post(hook *webhook, notification notification) error {
+	body, err := hook.payload(notification)
+	if err != nil {
+		return fmt.Errorf("Could not render payload: %v", err)
+	}
+	resp, err := n.client.Post(hook.URL.raw, "application/json", bytes.NewReader(body))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// the error would repeat the URL
+			err = urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// wait waits for the notifications being posted.
+func (n *notifier) wait() {
+	n.deliveries.wait()
+}
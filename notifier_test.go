@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookStandIn records the bodies posted to it.
+type webhookStandIn struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newWebhookStandIn() *webhookStandIn {
+	s := &webhookStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+// received returns the bodies posted since the last call.
+func (s *webhookStandIn) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bodies := s.bodies
+	s.bodies = nil
+	return bodies
+}
+
+func (s *webhookStandIn) events(t *testing.T) []notification {
+	var notifications []notification
+	for _, body := range s.received() {
+		var n notification
+		assert.NoError(t, json.Unmarshal([]byte(body), &n), body)
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+func newTestWebhooks(t *testing.T, data string) []*webhook {
+	c, err := parseConfig([]byte(data))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return c.Webhooks
+}
+
+func newNotifierTestHealthcheck(webhooks []*webhook) *healthcheck {
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil)})
+	h.update(&lagSettings{source: h.current().source, filters: h.current().filters, thresholds: h.current().thresholds, webhooks: webhooks})
+	return h
+}
+
+func groupLag(consumerGroup string, err error) consumerGroupLag {
+	return consumerGroupLag{cluster: "local", consumerGroup: consumerGroup, severity: 1, err: err}
+}
+
+func snapshotAt(at time.Time, lags ...consumerGroupLag) *lagSnapshot {
+	return &lagSnapshot{fetchedAt: at, clusters: []clusterLag{{cluster: "local", consumerGroups: lags}}}
+}
+
+func TestNotifierTransitions(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	generic, slack := newWebhookStandIn(), newWebhookStandIn()
+	defer generic.Close()
+	defer slack.Close()
+	h := newNotifierTestHealthcheck(newTestWebhooks(t, `{"webhooks": [{"url": "`+generic.URL+`"}, {"url": "`+slack.URL+`", "format": "slack"}]}`))
+	n := newNotifier(http.DefaultClient)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	lagging := errors.New("content-notifications consumer group is lagging behind with 20 messages.")
+
+	n.observe(h.evaluate(snapshotAt(start, groupLag("content-notifications", lagging), groupLag("concept-reader", nil))), h.current().webhooks)
+	n.wait()
+	events := generic.events(t)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, eventLagging, events[0].Event)
+		assert.True(t, events[0].Failing)
+		assert.Equal(t, "kafka-lagcheck-consumer-group-lag-local-content-notifications", events[0].CheckID)
+		assert.Equal(t, "content-notifications", events[0].ConsumerGroup)
+		assert.Equal(t, "local", events[0].Cluster)
+		assert.Equal(t, uint8(1), events[0].Severity)
+		assert.Equal(t, lagging.Error(), events[0].Message)
+		assert.Equal(t, start, events[0].Since)
+	}
+	assert.Equal(t, []string{`{"text":":red_circle: Cluster local: content-notifications consumer group is lagging behind with 20 messages."}`}, slack.received())
+
+	// still lagging, then unknown: nothing new to notify
+	n.observe(h.evaluate(snapshotAt(start.Add(time.Minute), groupLag("content-notifications", lagging), groupLag("concept-reader", nil))), h.current().webhooks)
+	unknown := groupLag("content-notifications", nil)
+	unknown.unknown = errors.New("context deadline exceeded")
+	n.observe(h.evaluate(snapshotAt(start.Add(2*time.Minute), unknown, groupLag("concept-reader", nil))), h.current().webhooks)
+	n.wait()
+	assert.Empty(t, generic.received())
+
+	n.observe(h.evaluate(snapshotAt(start.Add(3*time.Minute), groupLag("content-notifications", nil), groupLag("concept-reader", nil))), h.current().webhooks)
+	n.wait()
+	events = generic.events(t)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, eventRecovered, events[0].Event)
+		assert.False(t, events[0].Failing)
+		assert.Equal(t, "Consumer group content-notifications on cluster local recovered after 3m0s.", events[0].Text)
+	}
+	assert.Equal(t, []string{`{"text":":white_check_mark: Consumer group content-notifications on cluster local recovered after 3m0s."}`}, slack.received())
+
+	// lagging while Burrow becomes unavailable: the consumer group is not known to have recovered
+	n.observe(h.evaluate(snapshotAt(start.Add(4*time.Minute), groupLag("content-notifications", lagging))), h.current().webhooks)
+	down := &lagSnapshot{fetchedAt: start.Add(5 * time.Minute), circuit: breakerStatus{state: circuitOpen, failures: 10, openedAt: start.Add(5 * time.Minute), retryAt: start.Add(6 * time.Minute)}}
+	n.observe(h.evaluate(down), h.current().webhooks)
+	n.wait()
+	events = generic.events(t)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, eventLagging, events[0].Event)
+		assert.Equal(t, eventBurrowUnavailable, events[1].Event)
+		assert.Equal(t, "kafka-lagcheck-burrow", events[1].CheckID)
+		assert.True(t, strings.HasPrefix(events[1].Text, "Burrow is unavailable, circuit breaker open since 2026-10-16T12:05:00Z"), events[1].Text)
+	}
+
+	// Burrow is back and the consumer group is gone
+	n.observe(h.evaluate(snapshotAt(start.Add(7*time.Minute), groupLag("concept-reader", nil))), h.current().webhooks)
+	n.wait()
+	events = generic.events(t)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, eventBurrowAvailable, events[0].Event)
+		assert.Equal(t, "Burrow is available again after 2m0s.", events[0].Text)
+		assert.Equal(t, eventRecovered, events[1].Event)
+		assert.Equal(t, "content-notifications", events[1].ConsumerGroup)
+	}
+}
+
+func TestNotifierRenotifiesAndFiltersEvents(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	server := newWebhookStandIn()
+	defer server.Close()
+	h := newNotifierTestHealthcheck(newTestWebhooks(t, `{"webhooks": [{"url": "`+server.URL+`", "events": ["lagging"], "renotifyInterval": "1h"}]}`))
+	n := newNotifier(http.DefaultClient)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	lagging := errors.New("content-notifications consumer group is lagging behind with 20 messages.")
+
+	for _, minutes := range []int{0, 30, 61, 90} {
+		n.observe(h.evaluate(snapshotAt(start.Add(time.Duration(minutes)*time.Minute), groupLag("content-notifications", lagging))), h.current().webhooks)
+	}
+	n.observe(h.evaluate(snapshotAt(start.Add(3*time.Hour), groupLag("content-notifications", nil))), h.current().webhooks)
+	n.wait()
+
+	events := server.events(t)
+	if assert.Len(t, events, 2, "the recovery is not notified") {
+		assert.False(t, events[0].Renotification)
+		assert.True(t, events[1].Renotification)
+		assert.Equal(t, start, events[1].Since)
+		assert.Equal(t, start.Add(61*time.Minute), events[1].Time)
+		assert.Equal(t, "Still failing since 2026-10-16T12:00:00Z. Cluster local: content-notifications consumer group is lagging behind with 20 messages.", events[1].Text)
+	}
+}
+
+func TestNotifierWebhookAddedOnReload(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	first, added := newWebhookStandIn(), newWebhookStandIn()
+	defer first.Close()
+	defer added.Close()
+	h := newNotifierTestHealthcheck(newTestWebhooks(t, `{"webhooks": [{"url": "`+first.URL+`"}]}`))
+	n := newNotifier(http.DefaultClient)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	lagging := errors.New("content-notifications consumer group is lagging behind with 20 messages.")
+
+	n.observe(h.evaluate(snapshotAt(start, groupLag("content-notifications", lagging))), h.current().webhooks)
+	n.wait()
+	assert.Len(t, first.received(), 1)
+
+	webhooks := newTestWebhooks(t, `{"webhooks": [{"url": "`+first.URL+`"}, {"url": "`+added.URL+`"}]}`)
+	n.observe(h.evaluate(snapshotAt(start.Add(time.Minute), groupLag("content-notifications", lagging))), webhooks)
+	n.observe(h.evaluate(snapshotAt(start.Add(2*time.Minute), groupLag("content-notifications", lagging))), webhooks)
+	n.wait()
+	assert.Empty(t, first.received())
+	events := added.events(t)
+	if assert.Len(t, events, 1, "the added webhook is told once about the check already failing") {
+		assert.Equal(t, eventLagging, events[0].Event)
+		assert.True(t, events[0].Renotification)
+		assert.Equal(t, start, events[0].Since)
+	}
+}
+
+func TestNotifierMissingCriticalGroupAndOutOfDateLags(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	server := newWebhookStandIn()
+	defer server.Close()
+	conf, err := parseConfig([]byte(`{"checks": [{"consumerGroup": "content-notifications", "critical": true, "team": "content"}], "webhooks": [{"url": "` + server.URL + `"}]}`))
+	assert.NoError(t, err)
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(""), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil), checks: newCheckRules(conf.Checks), maxSnapshotAge: time.Minute})
+	h.update(&lagSettings{source: h.current().source, filters: h.current().filters, thresholds: h.current().thresholds, checks: h.current().checks, webhooks: conf.Webhooks})
+	n := newNotifier(http.DefaultClient)
+	start := time.Now()
+
+	n.observe(h.evaluate(snapshotAt(start, groupLag("concept-reader", nil))), h.current().webhooks)
+	n.wait()
+	events := server.events(t)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, eventLagging, events[0].Event)
+		assert.Equal(t, "kafka-lagcheck-critical-consumer-group-content-notifications", events[0].CheckID)
+		assert.Equal(t, "content-notifications", events[0].ConsumerGroup)
+		assert.Equal(t, "content", events[0].Team)
+	}
+
+	// the missing critical group isn't known to be back while the lags are out of date
+	n.observe(h.evaluate(snapshotAt(start.Add(-10*time.Minute), groupLag("concept-reader", nil))), h.current().webhooks)
+	n.wait()
+	events = server.events(t)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, eventBurrowUnavailable, events[0].Event)
+		assert.Equal(t, "kafka-lagcheck-lag-snapshot", events[0].CheckID)
+		assert.True(t, strings.HasPrefix(events[0].Message, "Consumer group lags are out of date"), events[0].Message)
+	}
+}
+
+func TestNotifierSilencedConsumerGroup(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	server := newWebhookStandIn()
+	defer server.Close()
+	h := newNotifierTestHealthcheck(newTestWebhooks(t, `{"webhooks": [{"url": "`+server.URL+`"}]}`))
+	h.silences, _ = newSilences("")
+	_, err := h.silences.add(silence{ConsumerGroup: mustParsePattern("content-notifications"), Reason: "reindexing", EndsAt: time.Now().Add(time.Hour)}, time.Now())
+	assert.NoError(t, err)
+	n := newNotifier(http.DefaultClient)
+
+	n.observe(h.evaluate(snapshotAt(time.Now(), groupLag("content-notifications", errors.New("lagging")))), h.current().webhooks)
+	n.wait()
+	assert.Empty(t, server.received())
+}
+
+func TestWebhookPayloadTemplate(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	server := newWebhookStandIn()
+	defer server.Close()
+	h := newNotifierTestHealthcheck(newTestWebhooks(t, `
+webhooks:
+  - url: `+server.URL+`
+    template: '{"summary": {{json .Text}}, "group": {{json .ConsumerGroup}}, "severity": {{.Severity}}}'
+  - url: `+server.URL+`/slack
+    format: slack
+    template: '{{.Event}}: {{.ConsumerGroup}} "{{.Message}}"'
+`))
+	n := newNotifier(http.DefaultClient)
+
+	n.observe(h.evaluate(snapshotAt(time.Now(), groupLag("content-notifications", errors.New("lagging \"badly\"")))), h.current().webhooks)
+	n.wait()
+	assert.ElementsMatch(t, []string{
+		`{"summary": "Cluster local: lagging \"badly\"", "group": "content-notifications", "severity": 1}`,
+		`{"text":"lagging: content-notifications \"lagging \"badly\"\""}`,
+	}, server.received())
+}
+
+func TestWebhookURLIsNotLogged(t *testing.T) {
+	previous, err := parseConfig([]byte(`{"webhooks": [{"url": "https://hooks.slack.com/services/T00/B00/secret"}]}`))
+	assert.NoError(t, err)
+	next, err := parseConfig([]byte(`{"webhooks": [{"url": "https://hooks.slack.com/services/T00/B00/other"}]}`))
+	assert.NoError(t, err)
+
+	changes := diffConfigs(previous, next)
+	if assert.Len(t, changes, 1) {
+		assert.Contains(t, changes[0], "https://hooks.slack.com (")
+		assert.NotContains(t, changes[0], "secret")
+		assert.NotContains(t, changes[0], "other")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pattern matches names either as a glob using the path.Match syntax (e.g. "image-*"), which for names without
+// wildcards is simply an exact match, or, when wrapped in slashes, as a regular expression (e.g. "/^Cms.*Events$/").
+type pattern struct {
+	raw    string
+	regexp *regexp.Regexp
+}
+
+func parsePattern(raw string) (pattern, error) {
+	if raw == "" {
+		return pattern{}, fmt.Errorf("empty pattern")
+	}
+	if len(raw) > 2 && strings.HasPrefix(raw, "/") && strings.HasSuffix(raw, "/") {
+		re, err := regexp.Compile(raw[1 : len(raw)-1])
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid regular expression %s: %v", raw, err)
+		}
+		return pattern{raw: raw, regexp: re}, nil
+	}
+	if _, err := path.Match(raw, ""); err != nil {
+		return pattern{}, fmt.Errorf("invalid glob %s: %v", raw, err)
+	}
+	return pattern{raw: raw}, nil
+}
+
+func (p pattern) match(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+	matched, _ := path.Match(p.raw, name)
+	return matched
+}
+
+func (p pattern) String() string {
+	return p.raw
+}
+
+func (p *pattern) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	parsed, err := parsePattern(raw)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// MarshalText and UnmarshalText represent a pattern by its raw form in JSON.
+func (p pattern) MarshalText() ([]byte, error) {
+	return []byte(p.raw), nil
+}
+
+func (p *pattern) UnmarshalText(text []byte) error {
+	parsed, err := parsePattern(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
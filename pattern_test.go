@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternMatch(t *testing.T) {
+	var testCases = []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{pattern: "Concept", name: "Concept", matches: true},
+		{pattern: "Concept", name: "ConceptAnnotations", matches: false},
+		{pattern: "image-*", name: "image-publish-1", matches: true},
+		{pattern: "image-*", name: "content-image-publish", matches: false},
+		{pattern: "consumer-?", name: "consumer-1", matches: true},
+		{pattern: "/^Cms.*Events$/", name: "CmsPublicationEvents", matches: true},
+		{pattern: "/^Cms.*Events$/", name: "NativeCmsPublicationEvents", matches: false},
+		{pattern: "/annotator/", name: "xp-v2-annotator-red", matches: true},
+		{pattern: "/", name: "/", matches: true},
+	}
+
+	for _, tc := range testCases {
+		p, err := parsePattern(tc.pattern)
+		assert.NoError(t, err, tc.pattern)
+		assert.Equal(t, tc.matches, p.match(tc.name), "%s matching %s", tc.pattern, tc.name)
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	for _, raw := range []string{"", "image-[", "/image-(/"} {
+		_, err := parsePattern(raw)
+		assert.Error(t, err, raw)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+)
+
+// lagSnapshot holds the outcome of one round of fetching all consumer group statuses from Burrow.
+// err is set when the cluster list couldn't be retrieved, and circuit is the state of the circuit breaker at the end.
+type lagSnapshot struct {
+	fetchedAt time.Time
+	err       error
+	clusters  []clusterLag
+	circuit   breakerStatus
+}
+
+// clusterNames lists the clusters of the snapshot, separated by commas.
+func (s *lagSnapshot) clusterNames() string {
+	var names []string
+	for _, cluster := range s.clusters {
+		names = append(names, cluster.cluster)
+	}
+	return strings.Join(names, ", ")
+}
+
+// clusterLag holds the consumer groups of a single cluster. err is set when their list couldn't be retrieved.
+type clusterLag struct {
+	cluster        string
+	err            error
+	consumerGroups []consumerGroupLag
+}
+
+// consumerGroupLag is the lag check result of a single consumer group.
+type consumerGroupLag struct {
+	cluster       string
+	consumerGroup string
+	severity      uint8
+	status        *burrow.ConsumerGroupStatus
+	// breakdown is the lag per topic the consumer group was checked with, and topic the one its check is about.
+	breakdown groupLagBreakdown
+	topic     string
+	// trends is the evolution of the lag on the topics that are not whitelisted, on each topic and on each partition.
+	trends lagTrends
+	// flap holds the streaks of failing or passing evaluations of the consumer group.
+	flap flapStatus
+	// output describes the trend of a healthy consumer group that lags, or its failures not yet reported.
+	output string
+	err    error
+	// unknown is set instead of err when the lag couldn't be fetched in time, the circuit breaker is open or the lag
+	// on some partition couldn't be computed. The check then neither fails nor passes.
+	unknown error
+}
+
+func (s *lagSnapshot) age() time.Duration {
+	return time.Since(s.fetchedAt)
+}
+
+// poller periodically fetches a new lagSnapshot and keeps the latest one in memory,
+// so that incoming requests don't have to hit Burrow themselves.
+type poller struct {
+	fetch    func(ctx context.Context) *lagSnapshot
+	interval time.Duration
+	maxAge   time.Duration
+
+	mu       sync.RWMutex
+	snapshot *lagSnapshot
+	// listeners are called with every new snapshot, from the polling goroutine.
+	listeners []func(snapshot *lagSnapshot)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	doneCh chan struct{}
+}
+
+func newPoller(fetch func(ctx context.Context) *lagSnapshot, interval time.Duration, maxAge time.Duration) *poller {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &poller{
+		fetch:    fetch,
+		interval: interval,
+		maxAge:   maxAge,
+		ctx:      ctx,
+		cancel:   cancel,
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// onSnapshot registers a listener of the new snapshots. It has to be called before start.
+func (p *poller) onSnapshot(listener func(snapshot *lagSnapshot)) {
+	p.listeners = append(p.listeners, listener)
+}
+
+func (p *poller) start() {
+	go func() {
+		defer close(p.doneCh)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		p.poll()
+		for {
+			select {
+			case <-ticker.C:
+				p.poll()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop cancels any fetch in progress and waits for the polling to end.
+func (p *poller) stop() {
+	p.cancel()
+	<-p.doneCh
+}
+
+// poll fetches a new snapshot, giving up on the requests to Burrow still pending when the next poll is due.
+func (p *poller) poll() {
+	ctx := p.ctx
+	if p.interval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(p.ctx, p.interval)
+		defer cancel()
+	}
+	snapshot := p.fetch(ctx)
+	p.mu.Lock()
+	p.snapshot = snapshot
+	p.mu.Unlock()
+	for _, listener := range p.listeners {
+		listener(snapshot)
+	}
+}
+
+// current returns the latest snapshot, or nil if none was fetched yet.
+func (p *poller) current() *lagSnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.snapshot
+}
+
+func (p *poller) isStale(snapshot *lagSnapshot) bool {
+	return p.maxAge > 0 && snapshot.age() > p.maxAge
+}
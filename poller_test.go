@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollerKeepsLatestSnapshot(t *testing.T) {
+	var fetches int32
+	p := newPoller(func(ctx context.Context) *lagSnapshot {
+		atomic.AddInt32(&fetches, 1)
+		return &lagSnapshot{fetchedAt: time.Now()}
+	}, 10*time.Millisecond, time.Minute)
+
+	assert.Nil(t, p.current(), "snapshot before first poll")
+
+	p.start()
+	time.Sleep(55 * time.Millisecond)
+	p.stop()
+
+	assert.NotNil(t, p.current(), "snapshot after polling")
+	count := atomic.LoadInt32(&fetches)
+	assert.True(t, count >= 3, "expected several fetches, got %d", count)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, count, atomic.LoadInt32(&fetches), "fetches after stop")
+}
+
+func TestPollerStaleness(t *testing.T) {
+	var testCases = []struct {
+		description string
+		maxAge      time.Duration
+		fetchedAt   time.Time
+		stale       bool
+	}{
+		{
+			description: "fresh snapshot",
+			maxAge:      time.Minute,
+			fetchedAt:   time.Now().Add(-10 * time.Second),
+			stale:       false,
+		},
+		{
+			description: "old snapshot",
+			maxAge:      time.Minute,
+			fetchedAt:   time.Now().Add(-2 * time.Minute),
+			stale:       true,
+		},
+		{
+			description: "staleness check disabled",
+			maxAge:      0,
+			fetchedAt:   time.Now().Add(-time.Hour),
+			stale:       false,
+		},
+	}
+
+	for _, tc := range testCases {
+		snapshot := &lagSnapshot{fetchedAt: tc.fetchedAt, err: errors.New("irrelevant")}
+		p := newPoller(func(ctx context.Context) *lagSnapshot { return snapshot }, time.Minute, tc.maxAge)
+		p.poll()
+		assert.Equal(t, tc.stale, p.isStale(p.current()), tc.description)
+	}
+}
+
+func TestPollerListeners(t *testing.T) {
+	snapshot := &lagSnapshot{fetchedAt: time.Now()}
+	p := newPoller(func(ctx context.Context) *lagSnapshot {
+		return snapshot
+	}, time.Minute, time.Minute)
+	var received []*lagSnapshot
+	p.onSnapshot(func(s *lagSnapshot) {
+		assert.Equal(t, s, p.current(), "listener called once the snapshot is current")
+		received = append(received, s)
+	})
+
+	p.poll()
+	p.poll()
+	assert.Equal(t, []*lagSnapshot{snapshot, snapshot}, received)
+}
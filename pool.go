@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// workerPool bounds the number of requests made to Burrow at the same time, across all clusters, and gives each of
+// them a deadline. A nil *workerPool runs every request right away, without deadline.
+type workerPool struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newWorkerPool(concurrency int, timeout time.Duration) *workerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &workerPool{slots: make(chan struct{}, concurrency), timeout: timeout}
+}
+
+// do runs the request once a slot is free, or returns the error of ctx if it is done first.
+func (p *workerPool) do(ctx context.Context, request func(ctx context.Context) error) error {
+	if p == nil {
+		return request(ctx)
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	return request(ctx)
+}
+
+// isTimeout tells whether err comes from a request that didn't complete in time, either because of its own deadline
+// or because the poll ran out of time while it was waiting for a slot.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
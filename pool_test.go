@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolBoundsConcurrency(t *testing.T) {
+	p := newWorkerPool(3, 0)
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.do(context.Background(), func(ctx context.Context) error {
+				current := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), maxRunning)
+}
+
+func TestWorkerPoolDeadlines(t *testing.T) {
+	p := newWorkerPool(1, 20*time.Millisecond)
+
+	err := p.do(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.True(t, isTimeout(err), "request deadline")
+
+	release := make(chan struct{})
+	go p.do(context.Background(), func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	time.Sleep(5 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	called := false
+	err = p.do(ctx, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	close(release)
+	assert.True(t, isTimeout(err), "deadline while waiting for a slot")
+	assert.False(t, called)
+}
+
+func TestNilWorkerPool(t *testing.T) {
+	var p *workerPool
+	err := p.do(context.Background(), func(ctx context.Context) error {
+		_, hasDeadline := ctx.Deadline()
+		assert.False(t, hasDeadline)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestIsTimeout(t *testing.T) {
+	assert.True(t, isTimeout(context.DeadlineExceeded))
+	assert.True(t, isTimeout(&net.DNSError{IsTimeout: true}))
+	assert.False(t, isTimeout(context.Canceled))
+	assert.False(t, isTimeout(errors.New("Burrow returned status 500")))
+	assert.False(t, isTimeout(nil))
+}
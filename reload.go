@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets editors and Kubernetes finish writing the config file before it is reloaded.
+const reloadDelay = 100 * time.Millisecond
+
+// configReloader reloads the config file when it changes or when asked to, builds the settings it defines and applies
+// them. An invalid config file is rejected and the previous settings are kept.
+type configReloader struct {
+	path  string
+	build func(conf *config) (*lagSettings, error)
+	apply func(settings *lagSettings)
+
+	mu      sync.Mutex
+	current *config
+
+	watcher *fsnotify.Watcher
+	doneCh  chan struct{}
+}
+
+func newConfigReloader(path string, conf *config, build func(conf *config) (*lagSettings, error), apply func(settings *lagSettings)) *configReloader {
+	return &configReloader{path: path, current: conf, build: build, apply: apply}
+}
+
+// reload loads the config file and applies it, logging what changed. It returns false if the config file is invalid.
+func (r *configReloader) reload() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conf, err := loadConfig(r.path)
+	if err != nil {
+		logger.Error("Keeping the previous config", "config_file", r.path, "error", err)
+		return false
+	}
+	settings, err := r.build(conf)
+	if err != nil {
+		logger.Error("Keeping the previous config", "config_file", r.path, "error", err)
+		return false
+	}
+
+	changes := diffConfigs(r.current, conf)
+	if len(changes) == 0 {
+		logger.Info("Reloaded config file, nothing changed", "config_file", r.path)
+		return true
+	}
+	r.apply(settings)
+	r.current = conf
+	for _, change := range changes {
+		logger.Info("Reloaded config file", "config_file", r.path, "change", change)
+	}
+	return true
+}
+
+// start reloads the config file whenever it is written, created or renamed in its directory, which also catches the
+// symlink swaps of Kubernetes config maps, and whenever a signal is received on signals.
+func (r *configReloader) start(signals <-chan os.Signal) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(r.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	r.watcher = watcher
+	r.doneCh = make(chan struct{})
+
+	go func() {
+		defer close(r.doneCh)
+		var timer <-chan time.Time
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				timer = time.After(reloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("Error watching config file", "config_file", r.path, "error", err)
+			case <-timer:
+				timer = nil
+				r.reloadIfChanged()
+			case sig := <-signals:
+				logger.Info("Reloading config file", "config_file", r.path, "signal", sig.String())
+				r.reload()
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadIfChanged reloads the config file unless its content is the one in use, as events are also received for the
+// other files of its directory.
+func (r *configReloader) reloadIfChanged() {
+	conf, err := loadConfig(r.path)
+	if err == nil {
+		r.mu.Lock()
+		unchanged := len(diffConfigs(r.current, conf)) == 0
+		r.mu.Unlock()
+		if unchanged {
+			return
+		}
+	}
+	r.reload()
+}
+
+func (r *configReloader) stop() {
+	if r.watcher == nil {
+		return
+	}
+	r.watcher.Close()
+	<-r.doneCh
+}
+
+// diffConfigs describes every section of the config file that differs between the previous and the new one.
+func diffConfigs(previous *config, next *config) []string {
+	sections := []struct {
+		name           string
+		previous, next interface{}
+	}{
+		{"burrowUrl", previous.BurrowURL, next.BurrowURL},
+		{"consumerGroups", previous.ConsumerGroups, next.ConsumerGroups},
+		{"topics", previous.Topics, next.Topics},
+		{"bridgeEnvironments", previous.BridgeEnvironments, next.BridgeEnvironments},
+		{"thresholds", previous.Thresholds, next.Thresholds},
+		{"checks", previous.Checks, next.Checks},
+		{"webhooks", previous.Webhooks, next.Webhooks},
+	}
+
+	var changes []string
+	for _, section := range sections {
+		before, _ := json.Marshal(section.previous)
+		after, _ := json.Marshal(section.next)
+		if !bytes.Equal(before, after) {
+			changes = append(changes, section.name+" changed from "+string(before)+" to "+string(after))
+		}
+	}
+	return changes
+}
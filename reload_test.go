@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+// testReloader records the settings applied by a configReloader of a config file in a temporary directory.
+type testReloader struct {
+	*configReloader
+	path string
+
+	mu      sync.Mutex
+	applied []*lagSettings
+}
+
+func newTestReloader(t *testing.T, dir string, content string) *testReloader {
+	path := filepath.Join(dir, "config.yml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	conf, err := loadConfig(path)
+	assert.NoError(t, err)
+
+	r := &testReloader{path: path}
+	build := func(conf *config) (*lagSettings, error) {
+		f, err := newFilters([]string{}, []string{}, nil, conf)
+		if err != nil {
+			return nil, err
+		}
+		return &lagSettings{source: burrow.NewClient(conf.BurrowURL), filters: f, thresholds: newThresholds(10, 5, 0, conf.Thresholds), checks: newCheckRules(conf.Checks), webhooks: conf.Webhooks}, nil
+	}
+	r.configReloader = newConfigReloader(path, conf, build, func(settings *lagSettings) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.applied = append(r.applied, settings)
+	})
+	return r
+}
+
+func (r *testReloader) appliedCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.applied)
+}
+
+func (r *testReloader) lastApplied() *lagSettings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.applied[len(r.applied)-1]
+}
+
+func TestConfigReload(t *testing.T) {
+	buf := &syncWriter{}
+	initLogs(buf, slog.LevelInfo, 0)
+	dir, err := ioutil.TempDir("", "kafka-lagcheck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := newTestReloader(t, dir, "burrowUrl: http://burrow-a\ntopics:\n  exclude: [Concept]\n")
+
+	assert.True(t, r.reload(), "unchanged config")
+	assert.Equal(t, 0, r.appliedCount())
+
+	assert.NoError(t, ioutil.WriteFile(r.path, []byte("burrowUrl: http://burrow-b\ntopics:\n  exclude: [Concept, /^Native/]\n"), 0644))
+	assert.True(t, r.reload())
+	if assert.Equal(t, 1, r.appliedCount()) {
+		assert.Equal(t, "http://burrow-b", r.lastApplied().source.(*burrow.Client).BaseURL())
+		assert.False(t, r.lastApplied().filters.checksTopic("NativeCmsPublicationEvents"))
+	}
+	var changes []interface{}
+	for _, line := range logLines(t, buf.Bytes()) {
+		if line["msg"] == "Reloaded config file" {
+			changes = append(changes, line["change"])
+		}
+	}
+	assert.Contains(t, changes, `burrowUrl changed from "http://burrow-a" to "http://burrow-b"`)
+	assert.Contains(t, changes, `topics changed from {"exclude":["Concept"]} to {"exclude":["Concept","/^Native/"]}`)
+
+	for _, invalid := range []string{"topics:\n  exclude: [/(/]\n", "thresholds:\n  - maxLagTolerance: 10\n", "unknownField: true\n"} {
+		assert.NoError(t, ioutil.WriteFile(r.path, []byte(invalid), 0644))
+		assert.False(t, r.reload(), invalid)
+	}
+	assert.Equal(t, 1, r.appliedCount(), "invalid configs are not applied")
+	assert.Equal(t, "http://burrow-b", r.current.BurrowURL, "previous config kept")
+	assert.Contains(t, string(buf.Bytes()), "Keeping the previous config")
+}
+
+func TestConfigReloadOnChangeAndSignal(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	dir, err := ioutil.TempDir("", "kafka-lagcheck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := newTestReloader(t, dir, "burrowUrl: http://burrow-a\n")
+	signals := make(chan os.Signal, 1)
+	assert.NoError(t, r.start(signals))
+	defer r.stop()
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.yml"), []byte("burrowUrl: http://burrow-c\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(r.path, []byte("burrowUrl: http://burrow-b\n"), 0644))
+	assert.Eventually(t, func() bool { return r.appliedCount() == 1 }, 2*time.Second, 10*time.Millisecond, "reload on change")
+	assert.Equal(t, "http://burrow-b", r.lastApplied().source.(*burrow.Client).BaseURL())
+
+	// Kubernetes config maps are updated by swapping a symlink.
+	replacement := filepath.Join(dir, "config.yml.new")
+	assert.NoError(t, ioutil.WriteFile(replacement, []byte("burrowUrl: http://burrow-d\n"), 0644))
+	assert.NoError(t, os.Rename(replacement, r.path))
+	assert.Eventually(t, func() bool { return r.appliedCount() == 2 }, 2*time.Second, 10*time.Millisecond, "reload on rename")
+
+	r.configReloader.mu.Lock()
+	r.current = &config{}
+	r.configReloader.mu.Unlock()
+	signals <- syscall.SIGHUP
+	assert.Eventually(t, func() bool { return r.appliedCount() == 3 }, 2*time.Second, 10*time.Millisecond, "reload on signal")
+	assert.Equal(t, "http://burrow-d", r.lastApplied().source.(*burrow.Client).BaseURL())
+}
+
+func TestHealthcheckUpdate(t *testing.T) {
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient("http://burrow-a"), clusters: []string{"local"}, thresholds: newThresholds(10, 5, 0, nil)})
+	assert.Equal(t, []string{"console-consumer", "xp-notifications-push-2"}, filterConsumerGroups(h.current().filters, []string{"console-consumer", "xp-notifications-push-2"}))
+
+	conf, err := parseConfig([]byte("consumerGroups:\n  deny: [console-consumer]\n"))
+	assert.NoError(t, err)
+	f, err := newFilters([]string{}, []string{}, nil, conf)
+	assert.NoError(t, err)
+	h.update(&lagSettings{source: burrow.NewClient("http://burrow-b"), filters: f, thresholds: newThresholds(10, 5, 0, nil)})
+
+	assert.Equal(t, []string{"xp-notifications-push-2"}, filterConsumerGroups(h.current().filters, []string{"console-consumer", "xp-notifications-push-2"}))
+	assert.Equal(t, "http://burrow-b", h.current().source.(*burrow.Client).BaseURL())
+}
+
+// reloadingSource reloads the settings of the healthcheck while it lists the consumer groups, as if the config file
+// changed in the middle of a poll.
+type reloadingSource struct {
+	h      *healthcheck
+	reload *lagSettings
+}
+
+func (s *reloadingSource) Clusters(ctx context.Context) ([]string, error) {
+	return []string{"local"}, nil
+}
+
+func (s *reloadingSource) Consumers(ctx context.Context, cluster string) ([]string, error) {
+	s.h.update(s.reload)
+	return []string{"content-notifications"}, nil
+}
+
+func (s *reloadingSource) ConsumerLag(ctx context.Context, cluster string, group string) (*burrow.ConsumerGroupStatus, error) {
+	lag := int64(50)
+	return &burrow.ConsumerGroupStatus{
+		Status:     burrow.StatusOK,
+		Partitions: []burrow.PartitionStatus{{Topic: "CmsPublicationEvents", CurrentLag: &lag, Status: burrow.StatusOK}},
+		TotalLag:   lag,
+	}, nil
+}
+
+func TestReloadDuringPoll(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	source := &reloadingSource{}
+	h := newHealthcheck(healthcheckOptions{source: source, thresholds: newThresholds(10, 5, 0, nil)})
+	source.h = h
+	source.reload = &lagSettings{source: source, filters: newTestFilters([]string{"CmsPublicationEvents"}, []string{}), thresholds: newThresholds(100, 50, 0, nil)}
+
+	h.poller.poll()
+
+	lag := h.poller.current().clusters[0].consumerGroups[0]
+	assert.Error(t, lag.err, "checked with the tolerances the poll started with")
+	assert.Equal(t, int64(50), lag.breakdown.lag, "lag broken down with the filters the poll started with")
+	assert.Equal(t, source.reload, h.current(), "next poll uses the reloaded settings")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+)
+
+// lagReport is the JSON representation of the latest snapshot served by the /__lag endpoint.
+type lagReport struct {
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Stale     bool            `json:"stale"`
+	Error     string          `json:"error,omitempty"`
+	Clusters  []clusterReport `json:"clusters"`
+}
+
+type clusterReport struct {
+	Cluster        string                `json:"cluster"`
+	Error          string                `json:"error,omitempty"`
+	ConsumerGroups []consumerGroupReport `json:"consumerGroups"`
+}
+
+// consumerGroupReport details the lag of a consumer group. TotalLag is the lag reported by Burrow, while Lag excludes
+// the whitelisted topics and is the one compared with the tolerances. A consumer group whose lag couldn't be fetched in
+// time is neither healthy nor failing but unknown.
+type consumerGroupReport struct {
+	ConsumerGroup string        `json:"consumerGroup"`
+	Team          string        `json:"team,omitempty"`
+	Healthy       bool          `json:"healthy"`
+	Unknown       bool          `json:"unknown,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	Output        string        `json:"output,omitempty"`
+	FailStreak    int           `json:"failStreak"`
+	PassStreak    int           `json:"passStreak"`
+	FirstFailed   *time.Time    `json:"firstFailed,omitempty"`
+	Silence       *silence      `json:"silence,omitempty"`
+	Status        burrow.Status `json:"status,omitempty"`
+	TotalLag      int64         `json:"totalLag"`
+	Lag           int64         `json:"lag"`
+	Trend         trendReport   `json:"trend"`
+	Topics        []topicReport `json:"topics"`
+}
+
+type topicReport struct {
+	Topic       string            `json:"topic"`
+	Whitelisted bool              `json:"whitelisted"`
+	Lag         int64             `json:"lag"`
+	Partitions  []partitionReport `json:"partitions"`
+}
+
+// partitionReport details a single partition. CurrentOffset is the last offset committed by the consumer group,
+// and EndOffset the offset at the end of the partition, i.e. CurrentOffset plus the lag.
+type partitionReport struct {
+	Partition     int32         `json:"partition"`
+	CurrentOffset int64         `json:"currentOffset"`
+	EndOffset     int64         `json:"endOffset"`
+	Lag           int64         `json:"lag"`
+	Status        burrow.Status `json:"status"`
+	Owner         string        `json:"owner"`
+	ClientID      string        `json:"clientId"`
+	Trend         trendReport   `json:"trend"`
+}
+
+// trendReport is a lagTrend with the rate in messages per second, negative when draining,
+// and the estimated time to catch up in seconds.
+type trendReport struct {
+	Direction  trendDirection `json:"direction"`
+	Rate       float64        `json:"rate"`
+	EtaSeconds float64        `json:"etaSeconds,omitempty"`
+}
+
+func newTrendReport(trend lagTrend) trendReport {
+	return trendReport{Direction: trend.direction, Rate: trend.rate, EtaSeconds: trend.eta.Seconds()}
+}
+
+// LagReport serves the latest snapshot as JSON, per cluster, consumer group, topic and partition.
+func (h *healthcheck) LagReport() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		snapshot := h.poller.current()
+		if snapshot == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Consumer group lags not yet available."})
+			return
+		}
+		if err := json.NewEncoder(w).Encode(h.lagReport(snapshot)); err != nil {
+			logger.Warn("Could not write lag report", "error", err)
+		}
+	}
+}
+
+func (h *healthcheck) lagReport(snapshot *lagSnapshot) lagReport {
+	report := lagReport{
+		FetchedAt: snapshot.fetchedAt.UTC(),
+		Stale:     h.poller.isStale(snapshot),
+		Clusters:  []clusterReport{},
+	}
+	if snapshot.err != nil {
+		report.Error = snapshot.err.Error()
+	}
+	rules := h.current().checks
+	for _, cluster := range snapshot.clusters {
+		clusterReport := clusterReport{Cluster: cluster.cluster, ConsumerGroups: []consumerGroupReport{}}
+		if cluster.err != nil {
+			clusterReport.Error = cluster.err.Error()
+		}
+		for _, lag := range cluster.consumerGroups {
+			clusterReport.ConsumerGroups = append(clusterReport.ConsumerGroups, h.consumerGroupReport(lag, rules))
+		}
+		report.Clusters = append(report.Clusters, clusterReport)
+	}
+	return report
+}
+
+func (h *healthcheck) consumerGroupReport(lag consumerGroupLag, rules *checkRules) consumerGroupReport {
+	report := consumerGroupReport{
+		ConsumerGroup: lag.consumerGroup,
+		Team:          rules.forConsumerGroup(lag.consumerGroup, lag.topic).team,
+		Healthy:       lag.err == nil && lag.unknown == nil,
+		Unknown:       lag.unknown != nil,
+		Output:        lag.output,
+		FailStreak:    lag.flap.failStreak,
+		PassStreak:    lag.flap.passStreak,
+		Topics:        []topicReport{},
+	}
+	if lag.err != nil {
+		report.Error = lag.err.Error()
+	}
+	if lag.unknown != nil {
+		report.Error = lag.unknown.Error()
+	}
+	report.Silence = h.silenceOf(lag)
+	if !lag.flap.firstFailed.IsZero() {
+		firstFailed := lag.flap.firstFailed.UTC()
+		report.FirstFailed = &firstFailed
+	}
+	if lag.status == nil {
+		return report
+	}
+
+	breakdown := lag.breakdown
+	report.Status = lag.status.Status
+	report.TotalLag = lag.status.TotalLag
+	report.Lag = breakdown.lag
+	report.Trend = newTrendReport(lag.trends.group)
+	for _, topic := range breakdown.topics {
+		report.Topics = append(report.Topics, newTopicReport(topic, false, lag.trends.partitions))
+	}
+	for _, topic := range breakdown.whitelisted {
+		report.Topics = append(report.Topics, newTopicReport(topic, true, lag.trends.partitions))
+	}
+	return report
+}
+
+func newTopicReport(topic topicLag, whitelisted bool, trends map[topicPartition]lagTrend) topicReport {
+	report := topicReport{Topic: topic.topic, Whitelisted: whitelisted, Lag: topic.lag, Partitions: []partitionReport{}}
+	for _, partition := range topic.partitions {
+		partitionReport := partitionReport{
+			Partition: partition.Partition,
+			Lag:       partition.Lag(),
+			Status:    partition.Status,
+			Owner:     partition.Owner,
+			ClientID:  partition.ClientID,
+			Trend:     newTrendReport(trends[topicPartition{topic: topic.topic, partition: partition.Partition}]),
+		}
+		if partition.End != nil {
+			partitionReport.CurrentOffset = partition.End.Offset
+			partitionReport.EndOffset = partition.End.Offset + partition.Lag()
+		}
+		report.Partitions = append(report.Partitions, partitionReport)
+	}
+	return report
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestLagReport(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	burrowUrl := "http://burrow.example.com"
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(burrowUrl), clusters: []string{"local"}, filters: newTestFilters([]string{"Concept"}, []string{}), thresholds: newThresholds(30, 10, 0, nil)})
+
+	req := httptest.NewRequest("GET", "/__lag", nil)
+	w := httptest.NewRecorder()
+	h.LagReport()(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "before the first poll")
+
+	consumersResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":     false,
+		"message":   "consumer list returned",
+		"consumers": []string{"consumer1"},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer", consumersResponse)
+	statusResponse, _ := httpmock.NewJsonResponder(200, map[string]interface{}{
+		"error":   false,
+		"message": "consumer status returned",
+		"status": map[string]interface{}{
+			"status":   "WARN",
+			"complete": 1.0,
+			"partitions": []map[string]interface{}{
+				{"topic": "Concept", "partition": 0, "status": "WARN", "owner": "/10.2.1.5", "client_id": "consumer-1", "current_lag": 500, "end": map[string]interface{}{"offset": 1000}},
+				{"topic": "CmsPublicationEvents", "partition": 0, "status": "OK", "owner": "/10.2.1.5", "client_id": "consumer-1", "current_lag": 5, "end": map[string]interface{}{"offset": 2000}},
+				{"topic": "CmsPublicationEvents", "partition": 1, "status": "WARN", "owner": "/10.2.1.6", "client_id": "consumer-2", "current_lag": 40, "end": map[string]interface{}{"offset": 3000}},
+			},
+			"partition_count": 3,
+			"totallag":        545,
+		},
+	})
+	httpmock.RegisterResponder("GET", burrowUrl+"/v3/kafka/local/consumer/consumer1/lag", statusResponse)
+	h.poller.poll()
+
+	w = httptest.NewRecorder()
+	h.LagReport()(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var report lagReport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.False(t, report.Stale)
+	if !assert.Len(t, report.Clusters, 1) || !assert.Len(t, report.Clusters[0].ConsumerGroups, 1) {
+		return
+	}
+	group := report.Clusters[0].ConsumerGroups[0]
+	assert.Equal(t, "consumer1", group.ConsumerGroup)
+	assert.False(t, group.Healthy)
+	assert.True(t, strings.HasPrefix(group.Error, "consumer1 consumer group is lagging behind with 45 messages on CmsPublicationEvents (partition 1: 40, partition 0: 5), ignoring whitelisted Concept (partition 0: 500). Status of the consumer group is WARN"), group.Error)
+	assert.Equal(t, 1, group.FailStreak)
+	assert.NotNil(t, group.FirstFailed)
+	assert.Equal(t, burrow.StatusWarning, group.Status)
+	assert.Equal(t, int64(545), group.TotalLag)
+	assert.Equal(t, int64(45), group.Lag)
+
+	assert.Equal(t, []topicReport{
+		{
+			Topic: "CmsPublicationEvents",
+			Lag:   45,
+			Partitions: []partitionReport{
+				{Partition: 1, CurrentOffset: 3000, EndOffset: 3040, Lag: 40, Status: burrow.StatusWarning, Owner: "/10.2.1.6", ClientID: "consumer-2"},
+				{Partition: 0, CurrentOffset: 2000, EndOffset: 2005, Lag: 5, Status: burrow.StatusOK, Owner: "/10.2.1.5", ClientID: "consumer-1"},
+			},
+		},
+		{
+			Topic:       "Concept",
+			Whitelisted: true,
+			Lag:         500,
+			Partitions: []partitionReport{
+				{Partition: 0, CurrentOffset: 1000, EndOffset: 1500, Lag: 500, Status: burrow.StatusWarning, Owner: "/10.2.1.5", ClientID: "consumer-1"},
+			},
+		},
+	}, group.Topics)
+
+	var raw map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	partition := raw["clusters"].([]interface{})[0].(map[string]interface{})["consumerGroups"].([]interface{})[0].(map[string]interface{})["topics"].([]interface{})[0].(map[string]interface{})["partitions"].([]interface{})[0].(map[string]interface{})
+	for _, field := range []string{"partition", "currentOffset", "endOffset", "lag", "status", "owner", "clientId"} {
+		assert.Contains(t, partition, field)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+// backoff retries the requests to Burrow that failed for a transient reason, waiting an exponentially growing and
+// jittered delay between the attempts. A nil *backoff makes a single attempt.
+type backoff struct {
+	retries int
+	initial time.Duration
+}
+
+func newBackoff(retries int, initial time.Duration) *backoff {
+	return &backoff{retries: retries, initial: initial}
+}
+
+// do makes the attempt until it succeeds, fails for a reason that isn't transient, runs out of retries or ctx is done.
+// onRetry is called before every retry. An attempt that ran out of time is retried as long as ctx isn't done, so every
+// attempt needs a deadline of its own.
+func (b *backoff) do(ctx context.Context, attempt func() error, onRetry func()) error {
+	for i := 0; ; i++ {
+		err := attempt()
+		if err == nil || b == nil || i >= b.retries || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		onRetry()
+		select {
+		case <-time.After(b.delay(i)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// delay returns a random duration between half and all of initial * 2^retry, so that the retries of the requests
+// that failed together are spread out.
+func (b *backoff) delay(retry int) time.Duration {
+	max := b.initial << uint(retry)
+	if max <= 0 {
+		return 0
+	}
+	return max/2 + time.Duration(rand.Int63n(int64(max/2)+1))
+}
+
+// isTransient tells whether a request for lags may succeed if made again: Burrow answered with a server error or
+// asked to slow down, Kafka answered with a retriable error, or either couldn't be reached or didn't answer in time.
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var burrowErr *burrow.Error
+	if errors.As(err, &burrowErr) {
+		return burrowErr.StatusCode >= 500 || burrowErr.StatusCode == 429
+	}
+	var kafkaErr *kerr.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Retriable
+	}
+	var urlErr *url.Error
+	var opErr *net.OpError
+	return errors.As(err, &urlErr) || errors.As(err, &opErr)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+func TestBackoffRetriesTransientFailures(t *testing.T) {
+	var testCases = []struct {
+		description string
+		errs        []error
+		attempts    int
+		err         bool
+	}{
+		{
+			description: "success",
+			errs:        []error{nil},
+			attempts:    1,
+		},
+		{
+			description: "server error then success",
+			errs:        []error{&burrow.Error{StatusCode: 500}, nil},
+			attempts:    2,
+		},
+		{
+			description: "unreachable then success",
+			errs:        []error{&url.Error{Op: "Get", Err: errors.New("connection refused")}, &burrow.Error{StatusCode: 429}, nil},
+			attempts:    3,
+		},
+		{
+			description: "attempt timed out then success",
+			errs:        []error{fmt.Errorf("Could not fetch the lag: %w", context.DeadlineExceeded), nil},
+			attempts:    2,
+		},
+		{
+			description: "out of retries",
+			errs:        []error{&burrow.Error{StatusCode: 503}, &burrow.Error{StatusCode: 503}, &burrow.Error{StatusCode: 503}, nil},
+			attempts:    3,
+			err:         true,
+		},
+		{
+			description: "consumer group not found",
+			errs:        []error{&burrow.Error{StatusCode: 404}, nil},
+			attempts:    1,
+			err:         true,
+		},
+		{
+			description: "kafka coordinator moved then broker unreachable then success",
+			errs:        []error{kerr.NotCoordinator, fmt.Errorf("unable to dial: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}), nil},
+			attempts:    3,
+		},
+		{
+			description: "kafka authorization failed",
+			errs:        []error{kerr.GroupAuthorizationFailed, nil},
+			attempts:    1,
+			err:         true,
+		},
+		{
+			description: "circuit breaker open",
+			errs:        []error{errCircuitOpen, nil},
+			attempts:    1,
+			err:         true,
+		},
+	}
+
+	for _, tc := range testCases {
+		b := newBackoff(2, time.Millisecond)
+		attempts, retries := 0, 0
+		err := b.do(context.Background(), func() error {
+			attempts++
+			return tc.errs[attempts-1]
+		}, func() {
+			retries++
+		})
+		assert.Equal(t, tc.attempts, attempts, tc.description)
+		assert.Equal(t, tc.attempts-1, retries, tc.description)
+		assert.Equal(t, tc.err, err != nil, tc.description)
+	}
+}
+
+func TestBackoffStopsWhenContextIsDone(t *testing.T) {
+	b := newBackoff(5, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	attempts := 0
+	err := b.do(ctx, func() error {
+		attempts++
+		return &burrow.Error{StatusCode: 500}
+	}, func() {})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := newBackoff(3, 100*time.Millisecond)
+	for retry, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			delay := b.delay(retry)
+			assert.True(t, delay >= max/2 && delay <= max, "retry %d waited %v", retry, delay)
+		}
+	}
+
+	var none *backoff
+	attempts := 0
+	none.do(context.Background(), func() error {
+		attempts++
+		return &burrow.Error{StatusCode: 500}
+	}, func() {})
+	assert.Equal(t, 1, attempts, "nil backoff")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// silence turns the failing checks of the matching consumer groups into passing ones until EndsAt.
+// A consumer group matches if it matches all the patterns that are set: Topic has to match one of the topics
+// the consumer group lags on.
+type silence struct {
+	ID            string    `json:"id"`
+	Cluster       *pattern  `json:"cluster,omitempty"`
+	ConsumerGroup *pattern  `json:"consumerGroup,omitempty"`
+	Topic         *pattern  `json:"topic,omitempty"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `json:"createdAt"`
+	EndsAt        time.Time `json:"endsAt"`
+}
+
+// invalidSilenceError tells why a silence can't be created as requested, as opposed to the failures to store it.
+type invalidSilenceError string
+
+func (e invalidSilenceError) Error() string {
+	return string(e)
+}
+
+func (s silence) validate() error {
+	if s.Cluster == nil && s.ConsumerGroup == nil && s.Topic == nil {
+		return invalidSilenceError("Silence must have a cluster, a consumerGroup or a topic")
+	}
+	if s.Reason == "" {
+		return invalidSilenceError("Silence must have a reason")
+	}
+	if s.EndsAt.IsZero() {
+		return invalidSilenceError("Silence must have an end time")
+	}
+	return nil
+}
+
+func (s silence) matches(cluster string, consumerGroup string, topics []string) bool {
+	if s.Cluster != nil && !s.Cluster.match(cluster) {
+		return false
+	}
+	if s.ConsumerGroup != nil && !s.ConsumerGroup.match(consumerGroup) {
+		return false
+	}
+	if s.Topic == nil {
+		return true
+	}
+	for _, topic := range topics {
+		if s.Topic.match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s silence) activeAt(now time.Time) bool {
+	return now.Before(s.EndsAt)
+}
+
+// silences holds the silences that haven't ended yet, saved to a JSON file on every change when a path is given
+// so that they survive restarts. A nil *silences silences nothing.
+type silences struct {
+	path string
+
+	mu       sync.RWMutex
+	silences []silence
+}
+
+func newSilences(path string) (*silences, error) {
+	s := &silences{path: path, silences: []silence{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read silences file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &s.silences); err != nil {
+		return nil, fmt.Errorf("Could not parse silences file %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// active returns the silences that haven't ended at now.
+func (s *silences) active(now time.Time) []silence {
+	result := []silence{}
+	if s == nil {
+		return result
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, existing := range s.silences {
+		if existing.activeAt(now) {
+			result = append(result, existing)
+		}
+	}
+	return result
+}
+
+// match returns the active silence of the consumer group ending the latest, or nil if it isn't silenced.
+func (s *silences) match(cluster string, consumerGroup string, topics []string, now time.Time) *silence {
+	var latest *silence
+	for _, active := range s.active(now) {
+		if active.matches(cluster, consumerGroup, topics) && (latest == nil || active.EndsAt.After(latest.EndsAt)) {
+			matched := active
+			latest = &matched
+		}
+	}
+	return latest
+}
+
+// add validates and stores a new silence, dropping the ones that ended. It returns an invalidSilenceError if the
+// silence isn't valid.
+func (s *silences) add(created silence, now time.Time) (silence, error) {
+	if err := created.validate(); err != nil {
+		return created, err
+	}
+	if !created.activeAt(now) {
+		return created, invalidSilenceError("Silence must end in the future")
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return created, err
+	}
+	created.ID = hex.EncodeToString(id)
+	created.CreatedAt = now.UTC()
+	created.EndsAt = created.EndsAt.UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	silences := []silence{created}
+	for _, existing := range s.silences {
+		if existing.activeAt(now) {
+			silences = append(silences, existing)
+		}
+	}
+	if err := s.save(silences); err != nil {
+		return created, err
+	}
+	s.silences = silences
+	return created, nil
+}
+
+// expire ends the silence with the given ID. It returns false if there is no such active silence.
+func (s *silences) expire(id string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	silences := []silence{}
+	found := false
+	for _, existing := range s.silences {
+		if existing.ID == id && existing.activeAt(now) {
+			found = true
+			continue
+		}
+		if existing.activeAt(now) {
+			silences = append(silences, existing)
+		}
+	}
+	if !found {
+		return false, nil
+	}
+	if err := s.save(silences); err != nil {
+		return true, err
+	}
+	s.silences = silences
+	return true, nil
+}
+
+// save writes the silences to a temporary file renamed over the silences file, so it is never left half written.
+func (s *silences) save(silences []silence) error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(silences, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Could not save silences: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Could not save silences: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Could not save silences: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("Could not save silences: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParsePattern(raw string) *pattern {
+	p, err := parsePattern(raw)
+	if err != nil {
+		panic(err)
+	}
+	return &p
+}
+
+func TestSilencesPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka-lagcheck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "silences.json")
+	now := time.Now()
+
+	s, err := newSilences(path)
+	assert.NoError(t, err)
+	assert.Empty(t, s.active(now), "missing file")
+
+	reindex, err := s.add(silence{ConsumerGroup: mustParsePattern("content-*"), Reason: "Reindexing", EndsAt: now.Add(time.Hour)}, now)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reindex.ID)
+	_, err = s.add(silence{Topic: mustParsePattern("/^Concept/"), Reason: "Concept migration", EndsAt: now.Add(2 * time.Hour)}, now)
+	assert.NoError(t, err)
+
+	reloaded, err := newSilences(path)
+	assert.NoError(t, err)
+	assert.Len(t, reloaded.active(now), 2)
+	assert.Len(t, reloaded.active(now.Add(90*time.Minute)), 1)
+
+	found, err := reloaded.expire(reindex.ID, now)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	found, err = reloaded.expire(reindex.ID, now)
+	assert.NoError(t, err)
+	assert.False(t, found, "already expired")
+
+	reloaded, err = newSilences(path)
+	assert.NoError(t, err)
+	active := reloaded.active(now)
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, "Concept migration", active[0].Reason)
+		assert.Equal(t, "/^Concept/", active[0].Topic.String())
+	}
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`[{"topic": "/(/"}]`), 0644))
+	_, err = newSilences(path)
+	assert.Error(t, err)
+}
+
+func TestSilencesMatch(t *testing.T) {
+	now := time.Now()
+	s, err := newSilences("")
+	assert.NoError(t, err)
+	_, err = s.add(silence{ConsumerGroup: mustParsePattern("content-*"), Reason: "Reindexing", EndsAt: now.Add(time.Hour)}, now)
+	assert.NoError(t, err)
+	_, err = s.add(silence{ConsumerGroup: mustParsePattern("content-*"), Reason: "Longer reindexing", EndsAt: now.Add(2 * time.Hour)}, now)
+	assert.NoError(t, err)
+	_, err = s.add(silence{Cluster: mustParsePattern("remote"), Topic: mustParsePattern("Concept*"), Reason: "Concept migration", EndsAt: now.Add(time.Hour)}, now)
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		cluster       string
+		consumerGroup string
+		topics        []string
+		reason        string
+	}{
+		{cluster: "local", consumerGroup: "content-ingester", reason: "Longer reindexing"},
+		{cluster: "local", consumerGroup: "image-publish", topics: []string{"ConceptAnnotations"}, reason: ""},
+		{cluster: "remote", consumerGroup: "image-publish", topics: []string{"CmsPublicationEvents", "ConceptAnnotations"}, reason: "Concept migration"},
+		{cluster: "remote", consumerGroup: "image-publish", topics: []string{"CmsPublicationEvents"}, reason: ""},
+	}
+	for _, tc := range testCases {
+		matched := s.match(tc.cluster, tc.consumerGroup, tc.topics, now)
+		if tc.reason == "" {
+			assert.Nil(t, matched, "%s %s", tc.cluster, tc.consumerGroup)
+		} else if assert.NotNil(t, matched, "%s %s", tc.cluster, tc.consumerGroup) {
+			assert.Equal(t, tc.reason, matched.Reason)
+		}
+	}
+	assert.Nil(t, s.match("local", "content-ingester", nil, now.Add(3*time.Hour)), "ended silences")
+
+	var nilSilences *silences
+	assert.Nil(t, nilSilences.match("local", "content-ingester", nil, now))
+}
+
+func TestInvalidSilences(t *testing.T) {
+	now := time.Now()
+	s, err := newSilences("")
+	assert.NoError(t, err)
+
+	_, err = s.add(silence{Reason: "Reindexing", EndsAt: now.Add(time.Hour)}, now)
+	assert.EqualError(t, err, "Silence must have a cluster, a consumerGroup or a topic")
+	_, err = s.add(silence{ConsumerGroup: mustParsePattern("content-*"), EndsAt: now.Add(time.Hour)}, now)
+	assert.EqualError(t, err, "Silence must have a reason")
+	_, err = s.add(silence{ConsumerGroup: mustParsePattern("content-*"), Reason: "Reindexing"}, now)
+	assert.EqualError(t, err, "Silence must have an end time")
+	_, err = s.add(silence{ConsumerGroup: mustParsePattern("content-*"), Reason: "Reindexing", EndsAt: now.Add(-time.Hour)}, now)
+	assert.EqualError(t, err, "Silence must end in the future")
+	assert.Empty(t, s.active(now))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+)
+
+// topicLag is the lag of a consumer group on a single topic, summed over the partitions Burrow reports for it.
+type topicLag struct {
+	topic      string
+	lag        int64
+	partitions []burrow.PartitionStatus
+}
+
+// groupLagBreakdown splits the lag of a consumer group between the topics that are checked and the whitelisted ones.
+type groupLagBreakdown struct {
+	// lag is the total lag of the consumer group minus its lag on whitelisted topics.
+	lag         int64
+	status      burrow.Status
+	topics      []topicLag
+	whitelisted []topicLag
+}
+
+// topic returns the checked topic on which the consumer group lags the most, or "" if it doesn't lag on any.
+func (b groupLagBreakdown) topic() string {
+	if len(b.topics) == 0 {
+		return ""
+	}
+	return b.topics[0].topic
+}
+
+// statusOn returns the status of the consumer group on the topic: OK if Burrow reports all its partitions OK, otherwise
+// the status of the consumer group.
+func (b groupLagBreakdown) statusOn(topic topicLag) burrow.Status {
+	if len(topic.partitions) == 0 {
+		return b.status
+	}
+	for _, partition := range topic.partitions {
+		if partition.Status != burrow.StatusOK {
+			return b.status
+		}
+	}
+	return burrow.StatusOK
+}
+
+// breakdownLag evaluates the lag of a consumer group per topic. The lag on whitelisted topics is subtracted from the
+// total lag, and the status of the consumer group is considered OK if only partitions of whitelisted topics are not OK.
+func breakdownLag(filters *filters, status *burrow.ConsumerGroupStatus) groupLagBreakdown {
+	partitions := status.Partitions
+	if len(partitions) == 0 && status.MaxLag != nil {
+		partitions = []burrow.PartitionStatus{*status.MaxLag}
+	}
+
+	breakdown := groupLagBreakdown{lag: status.TotalLag, status: status.Status}
+	var whitelistedLag int64
+	notOK, checkedNotOK := false, false
+	for _, topic := range lagPerTopic(partitions) {
+		checked := filters.checksTopic(topic.topic)
+		for _, partition := range topic.partitions {
+			if partition.Status != "" && partition.Status != burrow.StatusOK {
+				notOK = true
+				checkedNotOK = checkedNotOK || checked
+			}
+		}
+		if checked {
+			breakdown.topics = append(breakdown.topics, topic)
+			continue
+		}
+		breakdown.whitelisted = append(breakdown.whitelisted, topic)
+		whitelistedLag += topic.lag
+	}
+
+	breakdown.lag -= whitelistedLag
+	if breakdown.lag < 0 {
+		breakdown.lag = 0
+	}
+	if notOK && !checkedNotOK {
+		breakdown.status = burrow.StatusOK
+	}
+	return breakdown
+}
+
+// lagPerTopic groups the partitions by topic, with the most lagging topics and partitions first.
+func lagPerTopic(partitions []burrow.PartitionStatus) []topicLag {
+	var topics []topicLag
+	index := map[string]int{}
+	for _, partition := range partitions {
+		i, found := index[partition.Topic]
+		if !found {
+			i = len(topics)
+			index[partition.Topic] = i
+			topics = append(topics, topicLag{topic: partition.Topic})
+		}
+		topics[i].lag += partition.Lag()
+		topics[i].partitions = append(topics[i].partitions, partition)
+	}
+
+	for _, topic := range topics {
+		sort.SliceStable(topic.partitions, func(i, j int) bool {
+			if topic.partitions[i].Lag() != topic.partitions[j].Lag() {
+				return topic.partitions[i].Lag() > topic.partitions[j].Lag()
+			}
+			return topic.partitions[i].Partition < topic.partitions[j].Partition
+		})
+	}
+	sort.SliceStable(topics, func(i, j int) bool {
+		return topics[i].lag > topics[j].lag
+	})
+	return topics
+}
+
+// maxDescribedPartitions is the number of partitions listed in the failure message of a lagging consumer group.
+const maxDescribedPartitions = 5
+
+// describeTopics lists the most lagging partitions of the given topics grouped by topic,
+// e.g. "CmsPublicationEvents (partition 2: 700, partition 0: 500), Concept (partition 1: 20) and 3 more partitions".
+func describeTopics(topics []topicLag) string {
+	var lagging []burrow.PartitionStatus
+	for _, topic := range topics {
+		for _, partition := range topic.partitions {
+			if partition.Lag() > 0 {
+				lagging = append(lagging, partition)
+			}
+		}
+	}
+	sort.SliceStable(lagging, func(i, j int) bool {
+		return lagging[i].Lag() > lagging[j].Lag()
+	})
+	described := map[string]map[int32]bool{}
+	for i, partition := range lagging {
+		if i == maxDescribedPartitions {
+			break
+		}
+		if described[partition.Topic] == nil {
+			described[partition.Topic] = map[int32]bool{}
+		}
+		described[partition.Topic][partition.Partition] = true
+	}
+
+	var descriptions []string
+	for _, topic := range topics {
+		var partitions []string
+		for _, partition := range topic.partitions {
+			if described[topic.topic][partition.Partition] {
+				partitions = append(partitions, fmt.Sprintf("partition %d: %d", partition.Partition, partition.Lag()))
+			}
+		}
+		if len(partitions) > 0 {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", topic.topic, strings.Join(partitions, ", ")))
+		}
+	}
+	description := strings.Join(descriptions, ", ")
+	switch more := len(lagging) - maxDescribedPartitions; {
+	case more == 1:
+		description += " and 1 more partition"
+	case more > 1:
+		description += fmt.Sprintf(" and %d more partitions", more)
+	}
+	return description
+}
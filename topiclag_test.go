@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLagPerTopic(t *testing.T) {
+	lag := func(l int64) *int64 {
+		return &l
+	}
+	topics := lagPerTopic([]burrow.PartitionStatus{
+		{Topic: "Concept", Partition: 0, CurrentLag: lag(5)},
+		{Topic: "CmsPublicationEvents", Partition: 0, CurrentLag: lag(10)},
+		{Topic: "CmsPublicationEvents", Partition: 1, End: &burrow.Offset{Lag: 30}},
+		{Topic: "Concept", Partition: 1, CurrentLag: lag(0)},
+	})
+
+	assert.Len(t, topics, 2)
+	assert.Equal(t, "CmsPublicationEvents", topics[0].topic)
+	assert.Equal(t, int64(40), topics[0].lag)
+	assert.Equal(t, int32(1), topics[0].partitions[0].Partition, "most lagging partition first")
+	assert.Equal(t, "Concept", topics[1].topic)
+	assert.Equal(t, int64(5), topics[1].lag)
+}
+
+func TestDescribeTopics(t *testing.T) {
+	lag := func(l int64) *int64 {
+		return &l
+	}
+	var partitions []burrow.PartitionStatus
+	for i := int32(0); i < 6; i++ {
+		partitions = append(partitions, burrow.PartitionStatus{Topic: "CmsPublicationEvents", Partition: i, CurrentLag: lag(int64(i) * 10)})
+	}
+	partitions = append(partitions, burrow.PartitionStatus{Topic: "Concept", Partition: 3, CurrentLag: lag(45)})
+	partitions = append(partitions, burrow.PartitionStatus{Topic: "Concept", Partition: 4, CurrentLag: lag(0)})
+	partitions = append(partitions, burrow.PartitionStatus{Topic: "Concept", Partition: 5, CurrentLag: lag(5)})
+
+	assert.Equal(t, "CmsPublicationEvents (partition 5: 50, partition 4: 40, partition 3: 30, partition 2: 20), Concept (partition 3: 45) and 2 more partitions",
+		describeTopics(lagPerTopic(partitions)))
+	assert.Equal(t, "Concept (partition 3: 45)", describeTopics(lagPerTopic(partitions[6:8])))
+	assert.Equal(t, "", describeTopics(lagPerTopic(partitions[7:8])))
+}
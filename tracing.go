@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer of the spans of the service, see tracer.
+const tracerName = "github.com/Financial-Times/kafka-lagcheck"
+
+// Attributes of the spans of the consumer group evaluations.
+const (
+	lagKey     = attribute.Key("kafka.lag")
+	failingKey = attribute.Key("lagcheck.failing")
+	unknownKey = attribute.Key("lagcheck.unknown")
+	checkKey   = attribute.Key("lagcheck.check")
+)
+
+// tracer returns the tracer of the global tracer provider, which discards the spans unless initTracing was called.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// initTracing exports the spans to the OTLP/HTTP endpoint (e.g. http://otel-collector:4318), sampling the given
+// percentage of the traces that don't continue a sampled one, and propagates the trace context in the W3C format.
+// It returns the function flushing the spans left when the service stops.
+func initTracing(ctx context.Context, endpoint string, samplePercent int) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("Invalid tracing endpoint " + endpoint + ", it must be an absolute http or https URL")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(systemCode)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(float64(samplePercent)/100))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		warnRepeated(repeatKey("tracing"), "Could not export spans", "error", err)
+	}))
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a span for every request to the service, continuing the trace of the caller if any.
+// It is a mux middleware so that the span is named after the route rather than the path.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.HTTPRoute(route), semconv.URLPath(r.URL.Path)))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status), semconv.HTTPResponseBodySize(recorder.bytes))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// statusRecorder records the status code and the size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += n
+	return n, err
+}
+
+// traceChecks makes every check run by the fthealth runner in a span of its own, child of the span of ctx, so that a
+// slow check stands out. The checks are left as they are if that span isn't recorded.
+func traceChecks(ctx context.Context, checks []fthealth.Check) []fthealth.Check {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return checks
+	}
+	traced := make([]fthealth.Check, len(checks))
+	for i, check := range checks {
+		id, checker := check.ID, check.Checker
+		check.Checker = func() (string, error) {
+			_, span := tracer().Start(ctx, "Run check", trace.WithAttributes(checkKey.String(id)))
+			defer span.End()
+			output, err := checker()
+			span.SetAttributes(failingKey.Bool(err != nil))
+			return output, err
+		}
+		traced[i] = check
+	}
+	return traced
+}
+
+// endSpan ends the span, marking it as failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
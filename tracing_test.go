@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordSpans makes the global tracer provider record the spans until the end of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	return recorder
+}
+
+func spansNamed(spans []sdktrace.ReadOnlySpan, name string) []sdktrace.ReadOnlySpan {
+	var named []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == name {
+			named = append(named, span)
+		}
+	}
+	return named
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	attrs := attribute.NewSet(span.Attributes()...)
+	value, _ := attrs.Value(key)
+	return value
+}
+
+func TestTraceRequests(t *testing.T) {
+	recorder := recordSpans(t)
+	router := mux.NewRouter()
+	router.Use(traceRequests)
+	var handlerSpan trace.SpanContext
+	router.Path("/__silences/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No active silence with ID 42."}`))
+	})
+
+	req := httptest.NewRequest(http.MethodDelete, "/__silences/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "DELETE /__silences/{id}", span.Name())
+		assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String(), "continues the trace of the caller")
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+		assert.Equal(t, span.SpanContext().SpanID(), handlerSpan.SpanID(), "handler runs in the span")
+		assert.Equal(t, int64(404), spanAttribute(span, "http.response.status_code").AsInt64())
+		assert.Equal(t, int64(43), spanAttribute(span, "http.response.body.size").AsInt64())
+		assert.Equal(t, "/__silences/42", spanAttribute(span, "url.path").AsString())
+	}
+}
+
+func TestTracePoll(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	recorder := recordSpans(t)
+	var mu sync.Mutex
+	traceparents := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents[r.URL.Path] = r.Header.Get("traceparent")
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/v3/kafka/local/consumer":
+			w.Write([]byte(`{"error":false,"consumers":["content-notifications","concept-reader"]}`))
+		case "/v3/kafka/local/consumer/content-notifications/lag":
+			w.Write([]byte(`{"error":false,"status":{"status":"OK","complete":true,"partitions":[{"topic":"CmsPublicationEvents","current_lag":10}],"totallag":10}}`))
+		default:
+			w.Write([]byte(`{"error":false,"status":{"status":"OK","complete":true,"partitions":[{"topic":"Concept","current_lag":0}],"totallag":0}}`))
+		}
+	}))
+	defer server.Close()
+	h := newHealthcheck(healthcheckOptions{source: burrow.NewClient(server.URL), clusters: []string{"local"}, thresholds: newThresholds(5, 1, 0, nil)})
+
+	h.poller.poll()
+
+	spans := recorder.Ended()
+	polls := spansNamed(spans, "Poll consumer group lags")
+	if !assert.Len(t, polls, 1) {
+		return
+	}
+	poll := polls[0]
+	assert.False(t, poll.Parent().IsValid(), "root span")
+	clusters := spansNamed(spans, "Check cluster")
+	if assert.Len(t, clusters, 1) {
+		assert.Equal(t, poll.SpanContext().SpanID(), clusters[0].Parent().SpanID())
+		assert.Equal(t, "local", spanAttribute(clusters[0], burrow.ClusterKey).AsString())
+	}
+
+	evaluations := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spansNamed(spans, "Evaluate consumer group") {
+		assert.Equal(t, clusters[0].SpanContext().SpanID(), span.Parent().SpanID())
+		evaluations[spanAttribute(span, burrow.ConsumerGroupKey).AsString()] = span
+	}
+	if assert.Len(t, evaluations, 2) {
+		lagging := evaluations["content-notifications"]
+		assert.True(t, spanAttribute(lagging, failingKey).AsBool())
+		assert.Equal(t, int64(10), spanAttribute(lagging, lagKey).AsInt64())
+		assert.Equal(t, "CmsPublicationEvents", spanAttribute(lagging, burrow.TopicKey).AsString())
+		assert.False(t, spanAttribute(evaluations["concept-reader"], failingKey).AsBool())
+	}
+
+	burrowCalls := spansNamed(spans, "Burrow ConsumerLag")
+	if assert.Len(t, burrowCalls, 2) {
+		for _, call := range burrowCalls {
+			group := spanAttribute(call, burrow.ConsumerGroupKey).AsString()
+			if assert.Contains(t, evaluations, group) {
+				assert.Equal(t, evaluations[group].SpanContext().SpanID(), call.Parent().SpanID())
+			}
+			assert.Equal(t, int64(200), spanAttribute(call, "http.response.status_code").AsInt64())
+			assert.True(t, strings.HasPrefix(traceparents["/v3/kafka/local/consumer/"+group+"/lag"], "00-"+poll.SpanContext().TraceID().String()+"-"))
+		}
+	}
+}
+
+func TestTraceHealth(t *testing.T) {
+	initLogs(ioutil.Discard, slog.LevelInfo, 0)
+	recorder := recordSpans(t)
+	h := newNotifierTestHealthcheck(nil)
+	h.poller.snapshot = snapshotAt(time.Now(), groupLag("content-notifications", nil), groupLag("concept-reader", nil))
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "GET /__health")
+	req := httptest.NewRequest(http.MethodGet, "/__health", nil).WithContext(ctx)
+	h.Health()(httptest.NewRecorder(), req)
+	parent.End()
+
+	spans := recorder.Ended()
+	evaluations := spansNamed(spans, "Evaluate health")
+	if assert.Len(t, evaluations, 1) {
+		assert.Equal(t, parent.SpanContext().SpanID(), evaluations[0].Parent().SpanID())
+		assert.Equal(t, int64(2), spanAttribute(evaluations[0], "lagcheck.checks").AsInt64())
+		var checks []string
+		for _, span := range spansNamed(spans, "Run check") {
+			assert.Equal(t, evaluations[0].SpanContext().SpanID(), span.Parent().SpanID())
+			checks = append(checks, spanAttribute(span, checkKey).AsString())
+		}
+		assert.ElementsMatch(t, []string{"kafka-lagcheck-consumer-group-lag-local-content-notifications", "kafka-lagcheck-consumer-group-lag-local-concept-reader"}, checks)
+	}
+}
+
+func TestInitTracingInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"otel-collector:4318", "/v1/traces", "grpc://otel-collector:4317"} {
+		_, err := initTracing(context.Background(), endpoint, 100)
+		assert.EqualError(t, err, "Invalid tracing endpoint "+endpoint+", it must be an absolute http or https URL")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// trendDirection tells whether a lag is going down, staying the same or going up.
+type trendDirection int
+
+const (
+	trendUnknown trendDirection = iota
+	trendDraining
+	trendStable
+	trendGrowing
+)
+
+func (d trendDirection) String() string {
+	switch d {
+	case trendDraining:
+		return "draining"
+	case trendStable:
+		return "stable"
+	case trendGrowing:
+		return "growing"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText renders the direction by its name in the lag report.
+func (d trendDirection) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText parses a direction from its name, unknown names being trendUnknown.
+func (d *trendDirection) UnmarshalText(text []byte) error {
+	*d = trendUnknown
+	for _, direction := range []trendDirection{trendDraining, trendStable, trendGrowing} {
+		if direction.String() == string(text) {
+			*d = direction
+		}
+	}
+	return nil
+}
+
+const (
+	// minTrendSamples is the number of samples needed before classifying a lag.
+	minTrendSamples = 3
+	// stableLagChange is the change of lag over the history, relative to the average lag, below which a lag is stable.
+	stableLagChange = 0.05
+)
+
+// lagTrend is the evolution of a lag over the history window. rate is in messages per second, negative when
+// the lag is draining, and eta estimates when a draining lag will be fully consumed. catchingUp is set when it will be
+// within the maximum drain time of the history.
+type lagTrend struct {
+	direction  trendDirection
+	rate       float64
+	eta        time.Duration
+	catchingUp bool
+}
+
+// lagTrends are the trends of the lag of a consumer group on the topics that are not whitelisted, and of its lag on
+// each topic and on each partition.
+type lagTrends struct {
+	group      lagTrend
+	topics     map[string]lagTrend
+	partitions map[topicPartition]lagTrend
+}
+
+func (t lagTrend) String() string {
+	switch t.direction {
+	case trendDraining:
+		return fmt.Sprintf("draining at %.1f messages/s, caught up in about %v", -t.rate, t.eta.Round(time.Second))
+	case trendGrowing:
+		return fmt.Sprintf("growing at %.1f messages/s", t.rate)
+	default:
+		return t.direction.String()
+	}
+}
+
+type lagSample struct {
+	at  time.Time
+	lag int64
+}
+
+// topicPartition identifies a partition of a topic.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type historyKey struct {
+	cluster       string
+	consumerGroup string
+	topicPartition
+}
+
+// lagHistory keeps the lags recorded during the last window for every consumer group, topic and partition, to tell
+// a backlog that is being consumed from one that keeps piling up. A zero window disables it.
+type lagHistory struct {
+	window       time.Duration
+	maxDrainTime time.Duration
+
+	mu         sync.Mutex
+	groups     map[historyKey][]lagSample
+	topics     map[historyKey][]lagSample
+	partitions map[historyKey][]lagSample
+}
+
+func newLagHistory(window time.Duration, maxDrainTime time.Duration) *lagHistory {
+	return &lagHistory{
+		window:       window,
+		maxDrainTime: maxDrainTime,
+		groups:       map[historyKey][]lagSample{},
+		topics:       map[historyKey][]lagSample{},
+		partitions:   map[historyKey][]lagSample{},
+	}
+}
+
+// record adds the lag of a consumer group, excluding its whitelisted topics, and of all its topics and partitions to
+// the history, and returns their trends.
+func (h *lagHistory) record(cluster string, consumerGroup string, breakdown groupLagBreakdown, at time.Time) lagTrends {
+	if h.window <= 0 {
+		return lagTrends{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	groupKey := historyKey{cluster: cluster, consumerGroup: consumerGroup}
+	h.groups[groupKey] = h.appendSample(h.groups[groupKey], lagSample{at: at, lag: breakdown.lag})
+	trends := lagTrends{group: h.trend(h.groups[groupKey]), topics: map[string]lagTrend{}, partitions: map[topicPartition]lagTrend{}}
+
+	for _, topics := range [][]topicLag{breakdown.topics, breakdown.whitelisted} {
+		for _, topic := range topics {
+			topicKey := historyKey{cluster: cluster, consumerGroup: consumerGroup, topicPartition: topicPartition{topic: topic.topic}}
+			h.topics[topicKey] = h.appendSample(h.topics[topicKey], lagSample{at: at, lag: topic.lag})
+			trends.topics[topic.topic] = h.trend(h.topics[topicKey])
+			for _, partition := range topic.partitions {
+				tp := topicPartition{topic: topic.topic, partition: partition.Partition}
+				key := historyKey{cluster: cluster, consumerGroup: consumerGroup, topicPartition: tp}
+				h.partitions[key] = h.appendSample(h.partitions[key], lagSample{at: at, lag: partition.Lag()})
+				trends.partitions[tp] = h.trend(h.partitions[key])
+			}
+		}
+	}
+	return trends
+}
+
+// trend returns the trend of the samples, catching up if they drain within the maximum drain time.
+func (h *lagHistory) trend(samples []lagSample) lagTrend {
+	trend := trendOf(samples)
+	trend.catchingUp = trend.direction == trendDraining && trend.eta <= h.maxDrainTime
+	return trend
+}
+
+func (h *lagHistory) appendSample(samples []lagSample, sample lagSample) []lagSample {
+	samples = append(samples, sample)
+	for len(samples) > 0 && sample.at.Sub(samples[0].at) > h.window {
+		samples = samples[1:]
+	}
+	return samples
+}
+
+// prune forgets the consumer groups, topics and partitions that were not recorded during the last window.
+func (h *lagHistory) prune(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, samples := range []map[historyKey][]lagSample{h.groups, h.topics, h.partitions} {
+		for key, s := range samples {
+			if len(s) == 0 || now.Sub(s[len(s)-1].at) > h.window {
+				delete(samples, key)
+			}
+		}
+	}
+}
+
+// trendOf fits a line through the samples with the least squares method. The lag is stable if it changed by less
+// than stableLagChange of its average over the samples, or by less than one message.
+func trendOf(samples []lagSample) lagTrend {
+	if len(samples) < minTrendSamples {
+		return lagTrend{}
+	}
+	start := samples[0].at
+	var sumT, sumLag, sumTT, sumTLag float64
+	for _, sample := range samples {
+		t := sample.at.Sub(start).Seconds()
+		lag := float64(sample.lag)
+		sumT += t
+		sumLag += lag
+		sumTT += t * t
+		sumTLag += t * lag
+	}
+	n := float64(len(samples))
+	denominator := n*sumTT - sumT*sumT
+	if denominator == 0 {
+		return lagTrend{}
+	}
+	rate := (n*sumTLag - sumT*sumLag) / denominator
+
+	span := samples[len(samples)-1].at.Sub(start).Seconds()
+	if change := math.Abs(rate * span); change < 1 || change < stableLagChange*sumLag/n {
+		return lagTrend{direction: trendStable, rate: rate}
+	}
+	if rate > 0 {
+		return lagTrend{direction: trendGrowing, rate: rate}
+	}
+	lag := float64(samples[len(samples)-1].lag)
+	return lagTrend{direction: trendDraining, rate: rate, eta: time.Duration(lag / -rate * float64(time.Second))}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-lagcheck/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrendOf(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	samples := func(lags ...int64) []lagSample {
+		var s []lagSample
+		for i, lag := range lags {
+			s = append(s, lagSample{at: start.Add(time.Duration(i) * 30 * time.Second), lag: lag})
+		}
+		return s
+	}
+
+	var testCases = []struct {
+		description string
+		samples     []lagSample
+		direction   trendDirection
+		rate        float64
+		eta         time.Duration
+	}{
+		{description: "not enough samples", samples: samples(3000, 1500), direction: trendUnknown},
+		{description: "draining", samples: samples(9000, 6000, 3000), direction: trendDraining, rate: -100, eta: 30 * time.Second},
+		{description: "growing", samples: samples(100, 400, 700, 1000), direction: trendGrowing, rate: 10},
+		{description: "stable with noise", samples: samples(1000, 1020, 990, 1010), direction: trendStable},
+		{description: "no lag", samples: samples(0, 0, 0), direction: trendStable},
+	}
+
+	for _, tc := range testCases {
+		trend := trendOf(tc.samples)
+		assert.Equal(t, tc.direction, trend.direction, tc.description)
+		if tc.direction != trendStable {
+			assert.InDelta(t, tc.rate, trend.rate, 0.001, tc.description)
+			assert.Equal(t, tc.eta, trend.eta, tc.description)
+		}
+	}
+}
+
+func TestLagHistory(t *testing.T) {
+	history := newLagHistory(time.Minute, time.Minute)
+	start := time.Unix(1500000000, 0)
+	breakdown := func(lag int64) groupLagBreakdown {
+		return groupLagBreakdown{
+			lag:    lag,
+			topics: []topicLag{{topic: "CmsPublicationEvents", lag: lag, partitions: []burrow.PartitionStatus{{Topic: "CmsPublicationEvents", Partition: 2, CurrentLag: &lag}}}},
+		}
+	}
+
+	var trends lagTrends
+	for i, lag := range []int64{100, 5000, 4000, 3000} {
+		trends = history.record("local", "consumer1", breakdown(lag), start.Add(time.Duration(i)*30*time.Second))
+	}
+	assert.Equal(t, trendDraining, trends.group.direction, "samples older than the window are dropped")
+	assert.InDelta(t, -33.333, trends.group.rate, 0.001)
+	assert.False(t, trends.group.catchingUp, "caught up in 90s, more than the maximum drain time")
+	assert.Equal(t, trendDraining, trends.topics["CmsPublicationEvents"].direction)
+	assert.Equal(t, trendDraining, trends.partitions[topicPartition{topic: "CmsPublicationEvents", partition: 2}].direction)
+
+	trends = history.record("local", "consumer1", breakdown(1000), start.Add(2*time.Minute))
+	assert.True(t, trends.group.catchingUp)
+	assert.True(t, trends.topics["CmsPublicationEvents"].catchingUp)
+
+	history.prune(start.Add(150 * time.Second))
+	assert.Len(t, history.groups, 1)
+	history.prune(start.Add(4 * time.Minute))
+	assert.Len(t, history.groups, 0, "consumer group not recorded during the last window")
+	assert.Len(t, history.topics, 0)
+	assert.Len(t, history.partitions, 0)
+
+	trends = newLagHistory(0, time.Minute).record("local", "consumer1", breakdown(100), start)
+	assert.Equal(t, trendUnknown, trends.group.direction, "disabled history")
+	assert.Nil(t, trends.partitions)
+}